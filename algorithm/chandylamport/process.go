@@ -0,0 +1,387 @@
+// Package chandylamport is the Chandy-Lamport snapshot algorithm for FIFO
+// channels, with concurrent initiators merged into one snapshot
+// (Spezialetti-Kearns) and Safra's termination detection on the side. A
+// Process runs it on a node.Node for an application, and reports its local
+// snapshots to a collect.Collector that assembles them into global ones.
+package chandylamport
+
+import (
+	"encoding/gob"
+	"sort"
+
+	"snapshot/app"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/spacetime"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// Generic message type
+type Message struct {
+	From   int
+	Seq    int // per-sender sequence number, identifies the message in a trace
+	Data   string
+	Clock  int                // sender's Lamport clock at the send
+	Vector vclock.VectorClock // sender's vector clock at the send
+}
+
+// ID identifies the message in a trace
+func (m Message) ID() trace.MessageID {
+	return trace.MessageID{From: m.From, Seq: m.Seq}
+}
+
+// Payload is the data the application sent
+func (m Message) Payload() string {
+	return m.Data
+}
+
+// Marker type for snapshot
+type Marker struct {
+	Initiator int
+	Snapshot  int // snapshot ID, concurrent initiations share one ID
+	Clock     int // sender's Lamport clock at the send
+}
+
+// Traced events of the algorithm's own messages, not part of a process's
+// history as trace.Verify sees it, only for observers
+const (
+	MarkerSendEvent    trace.EventKind = "marker-send"
+	MarkerReceiveEvent trace.EventKind = "marker-receive"
+	TokenSendEvent     trace.EventKind = "token-send" // Safra's termination detection
+	TokenReceiveEvent  trace.EventKind = "token-receive"
+)
+
+// LocalSnapshot is the completed snapshot of one process for one snapshot
+// ID, Initiator being the region it recorded in
+type LocalSnapshot = collect.Local[Message]
+
+// GlobalSnapshot is the union of all local snapshots taken under one ID
+type GlobalSnapshot = collect.Global[Message]
+
+// Diagram is how Chandy-Lamport snapshots are drawn by spacetime.FromTrace
+var Diagram = spacetime.Style{
+	ControlSend:    MarkerSendEvent,
+	ControlReceive: MarkerReceiveEvent,
+	Control:        "mark",
+	Arrow:          func(trace.Event) string { return "marker" },
+	Record:         "record",
+}
+
+// Register the wire types carried by node.TCPTransport
+func init() {
+	gob.Register(Message{})
+	gob.Register(Marker{})
+	gob.Register(Token{})
+}
+
+// recording is the in-progress snapshot state of a process for one snapshot ID
+type recording struct {
+	initiator      int                // initiator whose marker reached this process first
+	clock          int                // Lamport clock when the state was recorded
+	vector         vclock.VectorClock // vector clock when the state was recorded
+	recordedState  string
+	channelState   map[int][]Message // channel -> messages recorded
+	markerReceived map[int]bool      // channel -> marker seen, recording closed
+	borders        map[int]bool      // other initiators whose markers arrived here
+	complete       bool
+}
+
+// Process structure
+type Process struct {
+	*node.Node
+	app          app.Application     // the workload, whose state snapshots record
+	snapshots    map[int]*recording  // snapshot ID -> recording state
+	lastSnapshot int                 // highest snapshot ID seen so far
+	sent         int                 // messages sent so far
+	clock        int                 // Lamport clock, ticked by every event
+	vector       vclock.VectorClock  // vector clock, ticked alongside but only merged from messages
+	report       func(LocalSnapshot) // hands completed snapshots on, to a collector or checkpoints
+	Trace        *trace.Trace        // optional, records sends, receives and cuts
+	Safra        *Safra              // Safra's termination detection, if running
+}
+
+// NewProcess runs Chandy-Lamport on node n for the given application,
+// tracing to t if not nil and handing every completed local snapshot to
+// report if not nil
+func NewProcess(n *node.Node, a app.Application, t *trace.Trace, report func(LocalSnapshot)) *Process {
+	return &Process{Node: n, app: a, snapshots: map[int]*recording{}, vector: vclock.VectorClock{}, Trace: t, report: report}
+}
+
+// App is the application the process runs
+func (p *Process) App() app.Application {
+	return p.app
+}
+
+// LastSnapshot is the highest snapshot ID the process has seen
+func (p *Process) LastSnapshot() int {
+	return p.lastSnapshot
+}
+
+// Sending normal message
+func (p *Process) SendMessage(to int, data string) { //sending message to teh particular channel
+	if err := p.app.Sending(to, data); err != nil {
+		p.Logf("P%d cannot send '%s' to P%d: %v\n", p.ID, data, to, err)
+		return
+	}
+	p.sent++
+	clock, vector := p.tick()
+	// traced first, so the trace never shows the receive ahead of the send
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.SendEvent, From: p.ID, To: to, Seq: p.sent, Data: data, Clock: clock, Vector: vector})
+	if err := p.Send(to, Message{From: p.ID, Seq: p.sent, Data: data, Clock: clock, Vector: vector}); err != nil {
+		p.Logf("P%d: %v\n", p.ID, err)
+		return
+	}
+	p.Logf("P%d sends '%s' to P%d\n", p.ID, data, to)
+	if p.Safra != nil {
+		p.Safra.count++
+		// the message may have been the last of the process's work
+		p.passToken()
+	}
+}
+
+// Resend puts a message recorded in transit back on the channel to process
+// to after a rollback, traced as sent again
+func (p *Process) Resend(to int, m Message) error {
+	if err := p.Send(to, m); err != nil {
+		return err
+	}
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.SendEvent, From: p.ID, To: to, Seq: m.Seq, Data: m.Data, Clock: m.Clock, Vector: m.Vector})
+	return nil
+}
+
+// Sending marker message on all outgoing channels, each send its own event
+func (p *Process) sendMarker(snapshot, initiator int) {
+	for _, to := range p.Out {
+		clock, vector := p.tick()
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: MarkerSendEvent, From: p.ID, To: to, Snapshot: snapshot, Clock: clock, Vector: vector})
+		if err := p.Send(to, Marker{Initiator: initiator, Snapshot: snapshot, Clock: clock}); err != nil {
+			p.Logf("P%d: %v\n", p.ID, err)
+			return
+		}
+	}
+	p.Logf("P%d sends marker #%d on all outgoing channels\n", p.ID, snapshot)
+}
+
+// Advance the clocks for a local or send event, returning its timestamps
+func (p *Process) tick() (int, vclock.VectorClock) {
+	p.clock++
+	p.vector.Tick(p.ID)
+	return p.clock, p.vector.Copy()
+}
+
+// Advance the clocks past the sender's for a receive event
+func (p *Process) witness(clock int, vector vclock.VectorClock) (int, vclock.VectorClock) {
+	if clock > p.clock {
+		p.clock = clock
+	}
+	p.vector.Merge(vector)
+	return p.tick()
+}
+
+// Record local state for a snapshot and open recording on every incoming channel
+func (p *Process) recordState(snapshot, initiator int) *recording {
+	clock, vector := p.tick()
+	r := &recording{
+		initiator:      initiator,
+		recordedState:  p.app.State(),
+		clock:          clock,
+		vector:         vector,
+		channelState:   map[int][]Message{},
+		markerReceived: map[int]bool{},
+		borders:        map[int]bool{},
+	}
+	for _, src := range p.In {
+		r.channelState[src] = []Message{}
+		r.markerReceived[src] = false
+	}
+	p.snapshots[snapshot] = r
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.RecordEvent, Snapshot: snapshot, State: r.recordedState, Clock: clock, Vector: vector})
+	if snapshot > p.lastSnapshot {
+		p.lastSnapshot = snapshot
+	}
+	p.Logf("P%d records state for snapshot #%d at clock %d: '%s'\n", p.ID, snapshot, r.clock, r.recordedState)
+	return r
+}
+
+// Initiate a new snapshot from this process, returns its ID
+func (p *Process) Initiate() int {
+	// Processes initiating before seeing each other's markers pick the same
+	// ID, so their snapshots are merged rather than taken twice
+	snapshot := p.lastSnapshot + 1
+	p.recordState(snapshot, p.ID)
+	p.sendMarker(snapshot, p.ID)
+	p.checkComplete(snapshot)
+	return snapshot
+}
+
+// Snapshot is complete once its marker has arrived on every incoming channel
+func (p *Process) checkComplete(snapshot int) {
+	r := p.snapshots[snapshot]
+	if r.complete {
+		return
+	}
+	for _, closed := range r.markerReceived {
+		if !closed {
+			return
+		}
+	}
+	r.complete = true
+
+	// hand out a copy so later activity cannot change the result
+	snap := LocalSnapshot{
+		Snapshot:  snapshot,
+		Process:   p.ID,
+		Initiator: r.initiator,
+		State:     r.recordedState,
+		Clock:     r.clock,
+		Vector:    r.vector,
+		Channels:  map[int][]Message{},
+	}
+	for src, msgs := range r.channelState {
+		snap.Channels[src] = append([]Message{}, msgs...)
+	}
+	for b := range r.borders {
+		snap.Borders = append(snap.Borders, b)
+	}
+	sort.Ints(snap.Borders)
+	p.Logf("P%d snapshot #%d complete\n", p.ID, snapshot)
+	if p.report != nil {
+		p.report(snap)
+	}
+}
+
+// Roll back to a checkpointed local snapshot, with no snapshot in progress
+// and new IDs continuing after last
+func (p *Process) Restore(s LocalSnapshot, last int) {
+	if err := p.app.Restore(s.State); err != nil {
+		p.Logf("P%d: %v\n", p.ID, err)
+	}
+	p.snapshots = map[int]*recording{}
+	p.lastSnapshot = last
+	p.clock, p.vector = s.Clock, s.Vector.Copy()
+	p.Down = false
+}
+
+// Handle one message received on incoming channel from
+func (p *Process) Receive(from int, msg interface{}) {
+	switch m := msg.(type) {
+	case Message:
+		p.Logf("P%d receives '%s' from P%d\n", p.ID, m.Data, m.From)
+		clock, vector := p.witness(m.Clock, m.Vector)
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.ReceiveEvent, From: from, To: p.ID, Seq: m.Seq, Data: m.Data, Clock: clock, Vector: vector})
+		// Record as in-transit for every snapshot still open on this channel
+		for _, r := range p.snapshots {
+			if !r.complete && !r.markerReceived[from] {
+				r.channelState[from] = append(r.channelState[from], m)
+			}
+		}
+		// Local state keeps evolving, only the recorded copies are frozen
+		if err := p.app.Apply(m.From, m.Data); err != nil {
+			p.Logf("P%d: %v\n", p.ID, err)
+		}
+		if p.Safra != nil {
+			p.Safra.count--
+			p.Safra.black = true
+			p.passToken()
+		}
+
+	case Marker:
+		// Markers carry no vector clock, the cut is judged by application causality
+		clock, vector := p.witness(m.Clock, nil)
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: MarkerReceiveEvent, From: from, To: p.ID, Snapshot: m.Snapshot, Clock: clock, Vector: vector})
+		r, ok := p.snapshots[m.Snapshot]
+		if !ok {
+			// First marker of this snapshot → record state, channel it came on is empty
+			r = p.recordState(m.Snapshot, m.Initiator)
+			r.markerReceived[from] = true
+
+			// Forward marker on behalf of the same initiator
+			p.sendMarker(m.Snapshot, m.Initiator)
+		} else {
+			// Already recorded → close channel recording
+			r.markerReceived[from] = true
+			if m.Initiator != r.initiator {
+				// Marker from a concurrent initiator, remember the region border
+				r.borders[m.Initiator] = true
+			}
+			p.Logf("P%d receives marker #%d from P%d, channel state: %v\n",
+				p.ID, m.Snapshot, from, r.channelState[from])
+		}
+		p.checkComplete(m.Snapshot)
+
+	case Token:
+		clock, vector := p.witness(m.Clock, nil)
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: TokenReceiveEvent, From: from, To: p.ID, Clock: clock, Vector: vector})
+		if p.Safra != nil {
+			p.Safra.token = &m
+			p.passToken()
+		}
+	}
+}
+
+// Algorithm:
+
+// Process p:
+//     state_p               // local state
+//     recorded             // boolean: whether snapshot started
+//     recordedState_p      // snapshot of local state
+//     channelState[c_in]   // map: incoming channel -> list of in-transit messages
+
+// Upon normal message m received from process q on channel c_in:
+
+//     if recorded == false:
+//         // Snapshot not started yet
+//         state_p ← update(state_p, m)      // update local state normally
+//     else:
+//         // Snapshot started
+//         if marker_received[c_in] == false:
+//             // Channel c_in is still “open” for recording in-transit messages
+//             append m to channelState[c_in]
+//         // In both cases the message is applied, only recordedState_p is frozen
+//         state_p ← update(state_p, m)
+
+// Upon receiving Marker on channel c_in:
+
+//     if recorded == false:
+//         // First marker for this process → start snapshot
+//         recorded ← true
+//         recordedState_p ← state_p
+
+//         // Initialize channel buffers for all incoming channels
+//         for each channel c:
+//             channelState[c] ← empty list
+//             marker_received[c] ← false
+
+//         // Mark channel from which this marker arrived as “closed”
+//         marker_received[c_in] ← true
+
+//         // Send marker to all outgoing channels
+//         for each outgoing channel c_out:
+//             send Marker to c_out
+
+//     else:
+//         // Snapshot already started
+//         marker_received[c_in] ← true
+//         // stop recording this channel; channelState[c_in] is now final
+
+// Snapshot completion condition:
+
+//     if recorded == true AND marker_received[c] == true for all incoming channels c:
+//         Snapshot_p = (recordedState_p, channelState[c1], channelState[c2], ...)
+
+// Assumptions for chandy Lamport Algorithm:
+// 1. Reliable Channels: The communication channels between processes are reliable, meaning messages are neither lost nor duplicated or corrupted.
+//    -drop, -dup, -delay and -partition break it, -reliable restores it with sequence numbers, acks and resends.
+// 2. FIFO Channels: Messages sent from one process to another are received in the order they were sent.
+//    Without it a message can overtake the marker in either direction: run with -seed N -reorder 0.5 to
+//    see the cut lose in-transit messages or record ones sent after it. laiYang does not need FIFO.
+// 3. No Global Clock: There is no global clock or synchronized time among processes; processes run asynchronously. each process operates based on its local clock.
+// 4. Communication graph must be connected(should be able to reach each other directly or indirectly / the channels should be bidirectional).
+// 5. Processes can atomically record their local state.
+
+// Multiple snapshots (Spezialetti-Kearns):
+// - every marker carries a snapshot ID, each process keeps one recording per ID
+// - a process that initiates before seeing anyone else's marker uses ID = highest seen + 1,
+//   so concurrent initiators pick the same ID and their regions form one global snapshot
+// - a marker from a different initiator on the same ID marks a border between two regions
@@ -0,0 +1,91 @@
+package chandylamport
+
+import (
+	"snapshot/app"
+	"snapshot/trace"
+)
+
+// Token is Safra's termination detection token, sent around the ring of
+// processes
+type Token struct {
+	Count int  // basic messages sent minus received by the processes passed
+	Black bool // one of them received a basic message since the token last came
+	Clock int  // sender's Lamport clock at the send
+}
+
+// Safra is the part of a process in Safra's termination detection. Each
+// process counts the basic messages it sent minus those it received, and
+// turns black on receiving one. The initiator sends a token around the
+// ring, each passive process adding its count and blackening it if black,
+// and a white token coming back to a white initiator with a sum of zero
+// proves that no message is in transit and every process is passive.
+type Safra struct {
+	Next       int  // process the token goes on to
+	Initiator  bool // starts the rounds and decides
+	Rounds     int  // rounds started, at the initiator
+	Tokens     int  // tokens sent by this process
+	Terminated bool // the initiator detected termination
+
+	count int    // basic messages sent minus received
+	black bool   // received a basic message since the token last passed
+	token *Token // held until the process is passive
+}
+
+// Passive processes have no work of their own left
+func (p *Process) idle() bool {
+	i, ok := p.app.(app.Idler)
+	return !ok || i.Idle(p.app.State())
+}
+
+// JoinRing sets the process up for Safra's detection, passing the token on
+// to process next. Counting starts here, so it must come before the first
+// message.
+func (p *Process) JoinRing(next int, initiator bool) {
+	p.Safra = &Safra{Next: next, Initiator: initiator}
+}
+
+// Detected reports whether the process, as initiator, has detected
+// termination, after how many rounds, and how many tokens it sent
+func (p *Process) Detected() (terminated bool, rounds, tokens int) {
+	return p.Safra.Terminated, p.Safra.Rounds, p.Safra.Tokens
+}
+
+// Start Safra's detection at the initiator, the first round going out once
+// it is passive
+func (p *Process) DetectTermination() {
+	p.Safra.token = &Token{}
+	p.passToken()
+}
+
+// Pass a held token on once passive. Back at the initiator the token
+// either proves termination or the next round starts.
+func (p *Process) passToken() {
+	s := p.Safra
+	if s == nil || s.token == nil || s.Terminated || !p.idle() {
+		return
+	}
+	t := *s.token
+	s.token = nil
+	if s.Initiator {
+		if s.Rounds > 0 && !t.Black && !s.black && t.Count+s.count == 0 {
+			s.Terminated = true
+			p.Logf("P%d detects termination after %d rounds\n", p.ID, s.Rounds)
+			return
+		}
+		s.Rounds++
+		t = Token{}
+	} else {
+		t.Count += s.count
+		t.Black = t.Black || s.black
+	}
+	s.black = false
+	clock, vector := p.tick()
+	t.Clock = clock
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: TokenSendEvent, From: p.ID, To: s.Next, Clock: clock, Vector: vector})
+	if err := p.Send(s.Next, t); err != nil {
+		p.Logf("P%d: %v\n", p.ID, err)
+		return
+	}
+	s.Tokens++
+	p.Logf("P%d passes the token to P%d: count %d, black %v\n", p.ID, s.Next, t.Count, t.Black)
+}
@@ -0,0 +1,337 @@
+// Package laiyang is the Lai-Yang snapshot algorithm, which needs no FIFO
+// channels: every message carries the epoch it was sent in, so a process
+// can tell a message sent before a cut from one sent after, and control
+// messages count what each channel carried before the cut. A Process runs
+// it on a node.Node for an application, and reports its local snapshots
+// to a collect.Collector that assembles them into global ones.
+package laiyang
+
+import (
+	"encoding/gob"
+	"strconv"
+
+	"snapshot/app"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/spacetime"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// LYMessage piggybacks the sender's epoch at send time. A message sent in
+// epoch e is "white" for every snapshot after e and "red" for the others.
+type LYMessage struct {
+	From   int
+	Seq    int // per-sender sequence number, identifies the message in a trace
+	Data   string
+	Epoch  int
+	Clock  int                // sender's Lamport clock at the send
+	Vector vclock.VectorClock // sender's vector clock at the send
+}
+
+// ID identifies the message in a trace
+func (m LYMessage) ID() trace.MessageID {
+	return trace.MessageID{From: m.From, Seq: m.Seq}
+}
+
+// Payload is the data the application sent
+func (m LYMessage) Payload() string {
+	return m.Data
+}
+
+// LYControl is sent on every outgoing channel when a process records the
+// snapshot of an epoch, telling the receiver how many messages it sent on
+// that channel before the cut
+type LYControl struct {
+	From  int
+	Epoch int
+	Sent  int
+	Clock int // sender's Lamport clock at the send
+}
+
+// traced events of the algorithm's own doings, not part of a process's
+// history as trace.Verify sees it, only for observers. Their Snapshot is
+// the epoch.
+const (
+	EpochEvent          trace.EventKind = "epoch" // process moved to a later epoch, the colour change
+	ControlSendEvent    trace.EventKind = "control-send"
+	ControlReceiveEvent trace.EventKind = "control-receive"
+)
+
+// LocalSnapshot is the completed snapshot of one process for one epoch: its
+// recorded state plus the messages recorded as in transit on every incoming
+// channel
+type LocalSnapshot = collect.Local[LYMessage]
+
+// GlobalSnapshot is the union of the local snapshots of all processes for one epoch
+type GlobalSnapshot = collect.Global[LYMessage]
+
+// Diagram is how Lai-Yang snapshots are drawn by spacetime.FromTrace: process
+// lines and messages turn red at the cut, and the control messages of the
+// epoch are drawn beside the application's
+var Diagram = spacetime.Style{
+	ControlSend:    ControlSendEvent,
+	ControlReceive: ControlReceiveEvent,
+	Control:        "ctl",
+	Arrow:          func(send trace.Event) string { return "sent " + send.Data },
+	Record:         "turns red",
+	Coloured:       true,
+}
+
+// register the wire types carried by node.TCPTransport
+func init() {
+	gob.Register(LYMessage{})
+	gob.Register(LYControl{})
+}
+
+// epochRecord is the snapshot a process recorded on entering an epoch
+type epochRecord struct {
+	recordedState string
+	clock         int                // lamport clock when the state was recorded
+	vector        vclock.VectorClock // vector clock when the state was recorded
+	// messages from earlier epochs received after the cut, per incoming channel
+	inTransit map[int][]LYMessage
+	// messages the sender put on each incoming channel before its cut, known once its control message arrives
+	expected map[int]int
+	complete bool
+}
+
+// Process is one participant of Lai-Yang on its node
+type Process struct {
+	*node.Node
+	epoch int             // current epoch, snapshot k is the cut between epochs k-1 and k
+	app   app.Application // the workload, whose state the snapshots record
+
+	// Lai-Yang bookkeeping:
+	// recorded snapshot of every epoch entered so far
+	snapshots map[int]*epochRecord
+	// messages sent per outgoing channel, and received per incoming channel and sender epoch
+	sentCount map[int]int
+	received  map[int]map[int]int
+	sent      int                // messages sent so far
+	clock     int                // lamport clock, ticked by every event
+	vector    vclock.VectorClock // vector clock, ticked alongside but only merged from messages
+
+	report func(LocalSnapshot) // hands completed snapshots on, to a collector or checkpoints
+	Trace  *trace.Trace        // optional, records sends, receives and cuts
+}
+
+// NewProcess runs Lai-Yang on node n for the given application, tracing to
+// t if not nil and handing every completed local snapshot to report if not
+// nil
+func NewProcess(n *node.Node, a app.Application, t *trace.Trace, report func(LocalSnapshot)) *Process {
+	return &Process{
+		Node:      n,
+		app:       a,
+		snapshots: map[int]*epochRecord{},
+		sentCount: map[int]int{},
+		received:  map[int]map[int]int{},
+		vector:    vclock.VectorClock{},
+		report:    report,
+		Trace:     t,
+	}
+}
+
+// App is the application the process runs
+func (p *Process) App() app.Application {
+	return p.app
+}
+
+// SendMessage sends a normal message piggybacking the sender's current epoch
+func (p *Process) SendMessage(to int, data string) {
+	if err := p.app.Sending(to, data); err != nil {
+		p.Logf("P%d cannot send '%s' to P%d: %v\n", p.ID, data, to, err)
+		return
+	}
+	p.sent++
+	clock, vector := p.tick()
+	msg := LYMessage{From: p.ID, Seq: p.sent, Data: data, Epoch: p.epoch, Clock: clock, Vector: vector}
+	// traced first, so the trace never shows the receive ahead of the send
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.SendEvent, From: p.ID, To: to, Seq: p.sent, Data: data, Snapshot: p.epoch, Clock: clock, Vector: vector})
+	if err := p.Send(to, msg); err != nil {
+		p.Logf("P%d: %v\n", p.ID, err)
+		return
+	}
+	p.sentCount[to]++
+	p.Logf("P%d (epoch=%d) sends '%s' to P%d\n", p.ID, p.epoch, data, to)
+}
+
+// LastSnapshot is the epoch the process is in, the last snapshot it recorded
+func (p *Process) LastSnapshot() int {
+	return p.epoch
+}
+
+// Resend puts a message recorded in transit back on the channel to process
+// to after a rollback, counting it among those sent before the next cut,
+// traced as sent again
+func (p *Process) Resend(to int, m LYMessage) error {
+	if err := p.Send(to, m); err != nil {
+		return err
+	}
+	p.sentCount[to]++
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.SendEvent, From: p.ID, To: to, Seq: m.Seq, Data: m.Data, Snapshot: m.Epoch, Clock: m.Clock, Vector: m.Vector})
+	return nil
+}
+
+// advance the clocks for a local or send event, returning its timestamps
+func (p *Process) tick() (int, vclock.VectorClock) {
+	p.clock++
+	p.vector.Tick(p.ID)
+	return p.clock, p.vector.Copy()
+}
+
+// advance the clocks past the sender's for a receive event
+func (p *Process) witness(clock int, vector vclock.VectorClock) (int, vclock.VectorClock) {
+	if clock > p.clock {
+		p.clock = clock
+	}
+	p.vector.Merge(vector)
+	return p.tick()
+}
+
+// Initiate starts the next snapshot from this process, returns its epoch
+func (p *Process) Initiate() int {
+	p.advance(p.epoch + 1)
+	return p.epoch
+}
+
+// move forward to epoch `to`, recording a snapshot for every epoch entered
+func (p *Process) advance(to int) {
+	for p.epoch < to {
+		p.epoch++
+		clock, vector := p.tick()
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: EpochEvent, Snapshot: p.epoch, Clock: clock, Vector: vector})
+		p.record(p.epoch)
+	}
+}
+
+// record local state for the snapshot of epoch k
+func (p *Process) record(k int) {
+	clock, vector := p.tick()
+	r := &epochRecord{
+		recordedState: p.app.State(),
+		clock:         clock,
+		vector:        vector,
+		inTransit:     make(map[int][]LYMessage),
+		expected:      make(map[int]int),
+	}
+	for _, src := range p.In {
+		r.inTransit[src] = []LYMessage{}
+	}
+	p.snapshots[k] = r
+	p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.RecordEvent, Snapshot: k, State: r.recordedState, Clock: clock, Vector: vector})
+	p.Logf("P%d enters epoch %d and records state at clock %d: '%s'\n", p.ID, k, r.clock, r.recordedState)
+
+	// tell every receiver how many of our messages precede the cut
+	for _, to := range p.Out {
+		clock, vector := p.tick()
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: ControlSendEvent, From: p.ID, To: to, Snapshot: k, Data: strconv.Itoa(p.sentCount[to]), Clock: clock, Vector: vector})
+		if err := p.Send(to, LYControl{From: p.ID, Epoch: k, Sent: p.sentCount[to], Clock: clock}); err != nil {
+			p.Logf("P%d: %v\n", p.ID, err)
+			continue
+		}
+		p.Logf("P%d sends control #%d to P%d: %d messages sent\n", p.ID, k, to, p.sentCount[to])
+	}
+	p.checkComplete(k)
+}
+
+// snapshot k is done once every sender's pre-cut messages have all arrived
+func (p *Process) checkComplete(k int) {
+	r := p.snapshots[k]
+	if r == nil || r.complete {
+		return
+	}
+	for _, src := range p.In {
+		want, ok := r.expected[src]
+		if !ok {
+			return
+		}
+		got := 0
+		for e, n := range p.received[src] {
+			if e < k {
+				got += n
+			}
+		}
+		if got < want {
+			return
+		}
+	}
+	r.complete = true
+
+	// hand out a copy so later activity cannot change the result
+	snap := LocalSnapshot{Snapshot: k, Process: p.ID, State: r.recordedState, Clock: r.clock, Vector: r.vector, Channels: map[int][]LYMessage{}}
+	for src, msgs := range r.inTransit {
+		snap.Channels[src] = append([]LYMessage{}, msgs...)
+	}
+	p.Logf("P%d snapshot #%d complete\n", p.ID, k)
+	if p.report != nil {
+		p.report(snap)
+	}
+}
+
+// Restore rolls back to a checkpointed local snapshot in epoch last, with no
+// snapshot in progress and nothing sent or received yet
+func (p *Process) Restore(s LocalSnapshot, last int) {
+	if err := p.app.Restore(s.State); err != nil {
+		p.Logf("P%d: %v\n", p.ID, err)
+	}
+	p.epoch = last
+	p.clock, p.vector = s.Clock, s.Vector.Copy()
+	p.snapshots = map[int]*epochRecord{}
+	p.sentCount = map[int]int{}
+	p.received = map[int]map[int]int{}
+	p.Down = false
+}
+
+// Receive handles one message received on incoming channel src
+func (p *Process) Receive(src int, raw interface{}) {
+	switch m := raw.(type) {
+	case LYMessage:
+		// a message from a later epoch moves us into it first,
+		// so the message itself lands after the cut
+		if m.Epoch > p.epoch {
+			p.Logf("P%d receives epoch %d message from P%d on channel %d\n", p.ID, m.Epoch, m.From, src)
+			p.advance(m.Epoch)
+		}
+		clock, vector := p.witness(m.Clock, m.Vector)
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: trace.ReceiveEvent, From: src, To: p.ID, Seq: m.Seq, Data: m.Data, Snapshot: m.Epoch, Clock: clock, Vector: vector})
+
+		if p.received[src] == nil {
+			p.received[src] = map[int]int{}
+		}
+		p.received[src][m.Epoch]++
+		// sent before the cuts of epochs m.Epoch+1..p.epoch, received after them → in-transit
+		// (epochs abandoned by a rollback have no record)
+		for k := m.Epoch + 1; k <= p.epoch; k++ {
+			if r := p.snapshots[k]; r != nil && !r.complete {
+				r.inTransit[src] = append(r.inTransit[src], m)
+				p.Logf("P%d records in-transit message for snapshot #%d on channel %d: {from:%d '%s' epoch=%d}\n",
+					p.ID, k, src, m.From, m.Data, m.Epoch)
+			}
+		}
+
+		// every message is applied, only the recorded states are frozen
+		if err := p.app.Apply(m.From, m.Data); err != nil {
+			p.Logf("P%d: %v\n", p.ID, err)
+		}
+		p.Logf("P%d (epoch=%d) applies message from P%d: '%s' -> state now '%s'\n",
+			p.ID, p.epoch, m.From, m.Data, p.app.State())
+
+	case LYControl:
+		// control messages carry no vector clock, the cut is judged by application causality
+		clock, vector := p.witness(m.Clock, nil)
+		p.Trace.Add(trace.Event{Process: p.ID, Kind: ControlReceiveEvent, From: src, To: p.ID, Snapshot: m.Epoch, Data: strconv.Itoa(m.Sent), Clock: clock, Vector: vector})
+		// the sender has entered the epoch, so we must too
+		p.advance(m.Epoch)
+		p.snapshots[m.Epoch].expected[src] = m.Sent
+		p.Logf("P%d receives control #%d from P%d: expecting %d messages\n",
+			p.ID, m.Epoch, m.From, m.Sent)
+
+	default:
+		// ignore unknown types
+		return
+	}
+	for k := 1; k <= p.epoch; k++ {
+		p.checkComplete(k)
+	}
+}
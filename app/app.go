@@ -0,0 +1,70 @@
+// Package app holds the workloads snapshot algorithms run: the Application
+// interface whose serialized state snapshots record, and the applications
+// the programs ship with.
+package app
+
+import "fmt"
+
+// Application is the workload a process runs. Snapshots record its state in
+// serialized form, and recovery restores it from there.
+type Application interface {
+	// Sending updates the state for data going out to process to, an
+	// error refusing the send
+	Sending(to int, data string) error
+	// Apply updates the state with data received from process from
+	Apply(from int, data string) error
+	// State serializes the current state
+	State() string
+	// Restore replaces the state with a serialized one
+	Restore(state string) error
+}
+
+// Idler is an application that can tell from a recorded state whether its
+// process was idle, with nothing to do until a message arrives. Processes
+// whose application is not one act only on messages and the scenario, so
+// they always count as idle.
+type Idler interface {
+	Idle(state string) bool
+}
+
+// Workload creates the application of process id from its initial state
+// in the topology
+type Workload func(id int, initial string) Application
+
+// IdleIn tells from a recorded state whether a process running the
+// workload was idle
+func IdleIn(workload Workload) func(pid int, state string) bool {
+	return func(pid int, state string) bool {
+		i, ok := workload(pid, state).(Idler)
+		return !ok || i.Idle(state)
+	}
+}
+
+// LogWorkload runs a Log on every process
+func LogWorkload(id int, initial string) Application {
+	return &Log{state: initial}
+}
+
+// Log is the default application: its state is the initial state followed
+// by the data of every message received, separated by "|"
+type Log struct {
+	state string
+}
+
+func (l *Log) Sending(to int, data string) error {
+	return nil
+}
+
+func (l *Log) Apply(from int, data string) error {
+	l.state = fmt.Sprintf("%s|%s", l.state, data)
+	return nil
+}
+
+func (l *Log) State() string {
+	return l.state
+}
+
+func (l *Log) Restore(state string) error {
+	l.state = state
+	return nil
+}
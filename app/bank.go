@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"snapshot/node"
+	"snapshot/sim"
+)
+
+// Bank is an account balance. Messages are transfers, their data the
+// amount, so money leaves on send and arrives on receive and the total
+// over balances and in-flight transfers never changes.
+type Bank struct {
+	balance int
+}
+
+// BankWorkload opens an account on every process, with the balance given
+// as its initial state in the topology or else the default
+func BankWorkload(balance int) Workload {
+	return func(id int, initial string) Application {
+		if b, err := Balance(initial); err == nil {
+			return &Bank{balance: b}
+		}
+		return &Bank{balance: balance}
+	}
+}
+
+// BankTotal is the money in the system at the start
+func BankTotal(top node.Topology, balance int) int {
+	total := 0
+	for _, id := range top.Nodes {
+		total += BankWorkload(balance)(id, top.InitialState(id)).(*Bank).balance
+	}
+	return total
+}
+
+// Balance reads the recorded state of an account
+func Balance(state string) (int, error) {
+	n, err := strconv.Atoi(state)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad balance %q", state)
+	}
+	return n, nil
+}
+
+// Amount reads a transfer
+func Amount(data string) (int, error) {
+	n, err := strconv.Atoi(data)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad amount %q", data)
+	}
+	return n, nil
+}
+
+func (b *Bank) Sending(to int, data string) error {
+	n, err := Amount(data)
+	if err != nil {
+		return err
+	}
+	if n > b.balance {
+		return fmt.Errorf("balance %d is short of %d", b.balance, n)
+	}
+	b.balance -= n
+	return nil
+}
+
+func (b *Bank) Apply(from int, data string) error {
+	n, err := Amount(data)
+	if err != nil {
+		return err
+	}
+	b.balance += n
+	return nil
+}
+
+func (b *Bank) State() string {
+	return strconv.Itoa(b.balance)
+}
+
+func (b *Bank) Restore(state string) error {
+	n, err := Balance(state)
+	if err != nil {
+		return err
+	}
+	b.balance = n
+	return nil
+}
+
+// BankScenario is a random transfer workload: every round each process
+// sends up to max to a random neighbour, and every few rounds a random
+// process initiates a snapshot
+func BankScenario(top node.Topology, rounds, max int, seed int64) sim.Scenario {
+	rng := rand.New(rand.NewSource(seed))
+	out := map[int][]int{}
+	for _, e := range top.Edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+	var sc sim.Scenario
+	for step := 1; step <= rounds; step++ {
+		for _, id := range top.Nodes {
+			if len(out[id]) == 0 {
+				continue
+			}
+			to := out[id][rng.Intn(len(out[id]))]
+			sc.Actions = append(sc.Actions, sim.Action{Step: step, Kind: sim.SendAction, From: id, To: to, Data: strconv.Itoa(1 + rng.Intn(max))})
+		}
+		if step%5 == 0 {
+			sc.Actions = append(sc.Actions, sim.Action{Step: step, Kind: sim.SnapshotAction, From: top.Nodes[rng.Intn(len(top.Nodes))]})
+		}
+	}
+	return sc
+}
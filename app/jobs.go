@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"snapshot/node"
+	"snapshot/sim"
+)
+
+// Worker is an application with work of its own between messages, as the
+// processes of a diffusing computation have: active until its work is
+// done, then passive until a message brings more. The simulation gives
+// every process a unit of work per step.
+type Worker interface {
+	Application
+	Idler
+	// Work does one unit of work, returning data to send on if it comes
+	// to that, ok false once there is no work left
+	Work() (data string, ok bool)
+}
+
+// Jobs is a diffusing computation: a message is a job with a budget, and
+// the process getting one owes fanout jobs of a smaller budget, sent one
+// per unit of work. The budgets run out, so the computation terminates,
+// every process passive and no job in transit.
+type Jobs struct {
+	fanout int
+	owed   []int // budgets of the jobs still to send
+}
+
+// JobsWorkload runs Jobs on every process, all passive at the start
+func JobsWorkload(fanout int) Workload {
+	return func(id int, initial string) Application {
+		return &Jobs{fanout: fanout}
+	}
+}
+
+// JobsScenario starts the computation with one job of the given budget
+// from the first process to its first neighbour
+func JobsScenario(top node.Topology, budget int) sim.Scenario {
+	for _, e := range top.Edges {
+		if e.From == top.Nodes[0] {
+			return sim.Scenario{Actions: []sim.Action{{Step: 1, Kind: sim.SendAction, From: e.From, To: e.To, Data: strconv.Itoa(budget)}}}
+		}
+	}
+	return sim.Scenario{}
+}
+
+// Budget reads a job
+func Budget(data string) (int, error) {
+	n, err := strconv.Atoi(data)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad job %q", data)
+	}
+	return n, nil
+}
+
+func (j *Jobs) Sending(to int, data string) error {
+	_, err := Budget(data)
+	return err
+}
+
+func (j *Jobs) Apply(from int, data string) error {
+	n, err := Budget(data)
+	if err != nil {
+		return err
+	}
+	for i := 0; n > 0 && i < j.fanout; i++ {
+		j.owed = append(j.owed, n-1)
+	}
+	return nil
+}
+
+func (j *Jobs) Work() (string, bool) {
+	if len(j.owed) == 0 {
+		return "", false
+	}
+	n := j.owed[0]
+	j.owed = j.owed[1:]
+	return strconv.Itoa(n), true
+}
+
+// State is "passive", or "owes" and the budgets of the jobs still to send
+func (j *Jobs) State() string {
+	if len(j.owed) == 0 {
+		return "passive"
+	}
+	var owed []string
+	for _, n := range j.owed {
+		owed = append(owed, strconv.Itoa(n))
+	}
+	return "owes " + strings.Join(owed, ",")
+}
+
+func (j *Jobs) Restore(state string) error {
+	j.owed = nil
+	if state == "passive" {
+		return nil
+	}
+	for _, s := range strings.Split(strings.TrimPrefix(state, "owes "), ",") {
+		n, err := Budget(s)
+		if err != nil {
+			return fmt.Errorf("bad state %q", state)
+		}
+		j.owed = append(j.owed, n)
+	}
+	return nil
+}
+
+func (j *Jobs) Idle(state string) bool {
+	return state == "passive"
+}
@@ -0,0 +1,123 @@
+// Package checkpoint keeps completed local snapshots on disk, one JSON file
+// per process and snapshot ID in a shared directory:
+//
+//	<dir>/snapshot-<id>-P<process>.json
+//
+// so that crashed processes can be recovered from the latest snapshot they
+// all completed, and runs of processes apart checked afterwards.
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"snapshot/collect"
+)
+
+func file(dir string, snapshot, process int) string {
+	return filepath.Join(dir, fmt.Sprintf("snapshot-%d-P%d.json", snapshot, process))
+}
+
+// Save writes a completed local snapshot. The file is renamed into place,
+// so a crash never leaves half a checkpoint behind.
+func Save[M collect.Message](dir string, s collect.Local[M]) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := file(dir, s.Snapshot, s.Process)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Latest returns the local snapshots of the highest snapshot ID that every
+// one of procs has checkpointed
+func Latest[M collect.Message](dir string, procs []int) (map[int]collect.Local[M], error) {
+	want := map[int]bool{}
+	for _, pid := range procs {
+		want[pid] = true
+	}
+	counts := map[int]int{}
+	files, err := filepath.Glob(filepath.Join(dir, "snapshot-*-P*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		var id, pid int
+		if _, err := fmt.Sscanf(filepath.Base(f), "snapshot-%d-P%d.json", &id, &pid); err == nil && want[pid] {
+			counts[id]++
+		}
+	}
+	best := -1
+	for id, n := range counts {
+		if n >= len(procs) && id > best {
+			best = id
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("no snapshot checkpointed by all of %v in %s", procs, dir)
+	}
+
+	locals := map[int]collect.Local[M]{}
+	for _, pid := range procs {
+		s, err := read[M](file(dir, best, pid))
+		if err != nil {
+			return nil, err
+		}
+		locals[pid] = s
+	}
+	return locals, nil
+}
+
+// ReadAll returns every local snapshot checkpointed to dir, by whichever
+// process, as processes running apart leave them for checking
+func ReadAll[M collect.Message](dir string) ([]collect.Local[M], error) {
+	files, err := filepath.Glob(filepath.Join(dir, "snapshot-*-P*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var locals []collect.Local[M]
+	for _, f := range files {
+		s, err := read[M](f)
+		if err != nil {
+			return nil, err
+		}
+		locals = append(locals, s)
+	}
+	return locals, nil
+}
+
+func read[M collect.Message](path string) (collect.Local[M], error) {
+	var s collect.Local[M]
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Clear removes the checkpoints of an earlier run from dir, creating it if
+// needed
+func Clear(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "snapshot-*-P*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
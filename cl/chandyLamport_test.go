@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"snapshot/app"
+	"snapshot/node"
+	"snapshot/sim"
+	"snapshot/sim/harness"
+)
+
+// The demo runs on the triangle, its concurrent initiators merged into one
+// consistent snapshot under every seed
+func TestDemo(t *testing.T) {
+	sc, err := sim.ParseScenario(strings.NewReader(demoScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangle := node.FullyConnected(1, 2, 3)
+	if err := sc.Validate(triangle); err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(0); seed < 20; seed++ {
+		r := algorithm.Simulate(triangle, sc, harness.Config{Seed: seed, Workload: app.LogWorkload})
+		if r.Total != 2 || r.Good != r.Total {
+			t.Errorf("seed %d: %d of %d snapshots consistent, want 2 of 2", seed, r.Good, r.Total)
+		}
+	}
+}
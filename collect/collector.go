@@ -0,0 +1,102 @@
+package collect
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"snapshot/node"
+)
+
+// Collector receives local snapshots from every process and assembles them
+// into global snapshots. Reports are taken as they are made and never keep
+// a process waiting, however many the collector has not been asked for yet.
+type Collector[M Message] struct {
+	mu       sync.Mutex
+	arrived  chan struct{}            // signalled when a report comes in
+	incoming map[int][]int            // participant -> incoming channels expected
+	locals   map[int]map[int]Local[M] // snapshot ID -> process -> local snapshot
+}
+
+// NewCollector expects a report from every process on the given nodes.
+// Processes report by calling Add.
+func NewCollector[M Message](nodes ...*node.Node) *Collector[M] {
+	c := &Collector[M]{
+		arrived:  make(chan struct{}, 1),
+		incoming: map[int][]int{},
+		locals:   map[int]map[int]Local[M]{},
+	}
+	for _, n := range nodes {
+		c.incoming[n.ID] = append([]int{}, n.In...)
+	}
+	return c
+}
+
+// Collect waits until every participant has reported snapshot id, or the
+// timeout expires, and returns the assembled global snapshot. Reports for
+// other snapshots that arrive meanwhile are kept for later calls.
+func (c *Collector[M]) Collect(id int, timeout time.Duration) Global[M] {
+	deadline := time.After(timeout)
+	for !c.reported(id) {
+		select {
+		case <-c.arrived:
+		case <-deadline:
+			return c.assemble(id)
+		}
+	}
+	return c.assemble(id)
+}
+
+// Report whether every participant has reported snapshot id
+func (c *Collector[M]) reported(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.locals[id]) == len(c.incoming)
+}
+
+// Add takes the report of a local snapshot. Processes call it themselves,
+// on their own event loops.
+func (c *Collector[M]) Add(s Local[M]) {
+	c.mu.Lock()
+	if c.locals[s.Snapshot] == nil {
+		c.locals[s.Snapshot] = map[int]Local[M]{}
+	}
+	c.locals[s.Snapshot][s.Process] = s
+	c.mu.Unlock()
+	select {
+	case c.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// Build the global snapshot for id from what has been reported so far
+func (c *Collector[M]) assemble(id int) Global[M] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var locals []Local[M]
+	for _, s := range c.locals[id] {
+		locals = append(locals, s)
+	}
+	g := Global[M]{ID: id, Regions: map[int][]int{}, Processes: map[int]Local[M]{}}
+	if merged := Merge(locals); len(merged) > 0 {
+		g = merged[0]
+	}
+
+	var procs []int
+	for pid := range c.incoming {
+		procs = append(procs, pid)
+	}
+	sort.Ints(procs)
+	for _, pid := range procs {
+		s, ok := g.Processes[pid]
+		if !ok {
+			g.Missing = append(g.Missing, pid)
+		}
+		for _, src := range c.incoming[pid] {
+			if _, recorded := s.Channels[src]; !recorded {
+				g.MissingChannels = append(g.MissingChannels, node.Channel{From: src, To: pid})
+			}
+		}
+	}
+	return g
+}
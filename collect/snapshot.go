@@ -0,0 +1,136 @@
+// Package collect assembles the local snapshots processes take into global
+// snapshots, whichever algorithm took them: the snapshot types every
+// algorithm reports, and the Collector they report to.
+package collect
+
+import (
+	"sort"
+
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// Message is an application message of a snapshot algorithm, as recorded in
+// transit by its snapshots
+type Message interface {
+	// ID identifies the message in a trace
+	ID() trace.MessageID
+	// Payload is the data the application sent
+	Payload() string
+}
+
+// Local is the completed snapshot of one process for one snapshot ID: its
+// recorded state plus the frozen state of every incoming channel
+type Local[M Message] struct {
+	Snapshot  int
+	Process   int
+	Initiator int   `json:",omitempty"` // region this process recorded in, for algorithms merging concurrent initiations
+	Borders   []int `json:",omitempty"` // concurrent initiators seen on incoming channels
+	State     string
+	Clock     int                // Lamport clock when the state was recorded
+	Vector    vclock.VectorClock // vector clock when the state was recorded
+	Channels  map[int][]M        // incoming channel -> in-transit messages
+}
+
+// Global is the union of all local snapshots taken under one ID
+type Global[M Message] struct {
+	ID         int
+	Initiators []int            // concurrent initiators merged into this snapshot
+	Regions    map[int][]int    // initiator -> processes that recorded on its marker
+	Processes  map[int]Local[M] // process -> local snapshot
+
+	// filled in by the collector when participants did not report in time
+	Missing         []int          // processes without a local snapshot
+	MissingChannels []node.Channel // channels whose state was not recorded
+}
+
+// Vectors are the vector clocks of the points where the processes recorded
+func (g Global[M]) Vectors() map[int]vclock.VectorClock {
+	points := map[int]vclock.VectorClock{}
+	for pid, l := range g.Processes {
+		points[pid] = l.Vector
+	}
+	return points
+}
+
+// Cut is the global state the snapshot recorded
+func (g Global[M]) Cut() predicate.Cut {
+	c := predicate.Cut{States: map[int]string{}, InTransit: map[node.Channel][]string{}}
+	for pid, l := range g.Processes {
+		c.States[pid] = l.State
+		for src, msgs := range l.Channels {
+			for _, m := range msgs {
+				ch := node.Channel{From: src, To: pid}
+				c.InTransit[ch] = append(c.InTransit[ch], m.Payload())
+			}
+		}
+	}
+	return c
+}
+
+// Complete reports whether every participant and channel is in the snapshot
+func (g Global[M]) Complete() bool {
+	return len(g.Missing) == 0 && len(g.MissingChannels) == 0
+}
+
+// Verify checks that the snapshot is a consistent cut of the run described
+// by events, see trace.Verify
+func (g Global[M]) Verify(events []trace.Event) error {
+	recorded := map[int]map[int][]trace.MessageID{}
+	for pid, l := range g.Processes {
+		recorded[pid] = map[int][]trace.MessageID{}
+		for src, msgs := range l.Channels {
+			for _, m := range msgs {
+				recorded[pid][src] = append(recorded[pid][src], m.ID())
+			}
+		}
+	}
+	return trace.Verify(events, g.ID, recorded)
+}
+
+// Recorded is every message the snapshot recorded in transit
+func (g Global[M]) Recorded() []trace.MessageID {
+	var ids []trace.MessageID
+	for _, l := range g.Processes {
+		for _, msgs := range l.Channels {
+			for _, m := range msgs {
+				ids = append(ids, m.ID())
+			}
+		}
+	}
+	return ids
+}
+
+// Merge groups local snapshots by ID. Regions started by concurrent
+// initiators share an ID, so they are merged into one global snapshot.
+func Merge[M Message](locals []Local[M]) []Global[M] {
+	byID := map[int]*Global[M]{}
+	var ids []int
+	for _, l := range locals {
+		g, ok := byID[l.Snapshot]
+		if !ok {
+			g = &Global[M]{ID: l.Snapshot, Regions: map[int][]int{}, Processes: map[int]Local[M]{}}
+			byID[l.Snapshot] = g
+			ids = append(ids, l.Snapshot)
+		}
+		g.Processes[l.Process] = l
+		if l.Initiator != 0 {
+			g.Regions[l.Initiator] = append(g.Regions[l.Initiator], l.Process)
+		}
+	}
+	sort.Ints(ids)
+
+	var out []Global[M]
+	for _, id := range ids {
+		g := byID[id]
+		for init, procs := range g.Regions {
+			sort.Ints(procs)
+			g.Initiators = append(g.Initiators, init)
+		}
+		sort.Ints(g.Initiators)
+		out = append(out, *g)
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"snapshot/app"
+	"snapshot/node"
+	"snapshot/sim"
+	"snapshot/sim/harness"
+)
+
+// the demo runs on the triangle, every epoch a consistent snapshot under
+// every seed. P3 enters epoch 1 itself when it initiates before hearing of
+// P1's, so there may be one or two.
+func TestDemo(t *testing.T) {
+	sc, err := sim.ParseScenario(strings.NewReader(demoScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangle := node.FullyConnected(1, 2, 3)
+	if err := sc.Validate(triangle); err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(0); seed < 20; seed++ {
+		r := algorithm.Simulate(triangle, sc, harness.Config{Seed: seed, Workload: app.LogWorkload})
+		if r.Total == 0 || r.Good != r.Total {
+			t.Errorf("seed %d: %d of %d snapshots consistent", seed, r.Good, r.Total)
+		}
+	}
+}
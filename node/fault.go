@@ -0,0 +1,154 @@
+package node
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults is what an unreliable network does to the messages it carries
+type Faults struct {
+	Drop      float64       // chance a message is lost
+	Duplicate float64       // chance a message is delivered twice
+	Delay     time.Duration // messages are held up to this long, so later ones can overtake them
+	Partition []Channel     // channels that lose every message...
+	Heal      time.Duration // ...until this long after the start, 0 for never
+}
+
+// Any reports whether the network misbehaves at all
+func (f Faults) Any() bool {
+	return f.Drop > 0 || f.Duplicate > 0 || f.Delay > 0 || len(f.Partition) > 0
+}
+
+// FaultyTransport wraps a transport, injecting faults into what is sent
+// over it. The seed makes the decisions repeatable, though with delays the
+// timing of deliveries is still up to the Go runtime.
+type FaultyTransport struct {
+	Log Logger // where failed delayed sends are reported, if anywhere
+
+	net    Transport
+	faults Faults
+	cut    map[Channel]bool
+	start  time.Time
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	timers map[*time.Timer]bool // delayed sends still to go
+	closed bool
+}
+
+func NewFaultyTransport(net Transport, faults Faults, seed int64) *FaultyTransport {
+	f := &FaultyTransport{
+		net:    net,
+		faults: faults,
+		cut:    map[Channel]bool{},
+		start:  time.Now(),
+		rng:    rand.New(rand.NewSource(seed)),
+		timers: map[*time.Timer]bool{},
+	}
+	for _, c := range faults.Partition {
+		f.cut[c] = true
+	}
+	return f
+}
+
+func (f *FaultyTransport) Send(from, to int, v interface{}) error {
+	if f.cut[Channel{From: from, To: to}] && (f.faults.Heal == 0 || time.Since(f.start) < f.faults.Heal) {
+		return nil
+	}
+
+	// one decision per copy, taken up front so they follow the seed
+	f.mu.Lock()
+	copies := 1
+	if f.rng.Float64() < f.faults.Duplicate {
+		copies = 2
+	}
+	var delays []time.Duration
+	for i := 0; i < copies; i++ {
+		if f.rng.Float64() < f.faults.Drop {
+			continue
+		}
+		var d time.Duration
+		if f.faults.Delay > 0 {
+			d = time.Duration(f.rng.Int63n(int64(f.faults.Delay)))
+		}
+		delays = append(delays, d)
+	}
+	f.mu.Unlock()
+
+	for _, d := range delays {
+		if d == 0 {
+			if err := f.net.Send(from, to, v); err != nil {
+				return err
+			}
+			continue
+		}
+		f.delay(d, from, to, v)
+	}
+	return nil
+}
+
+// delay sends v after d, unless the transport is closed by then
+func (f *FaultyTransport) delay(d time.Duration, from, to int, v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	var t *time.Timer
+	// the timer cannot take the lock before it is registered
+	t = time.AfterFunc(d, func() {
+		f.mu.Lock()
+		delete(f.timers, t)
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return
+		}
+		if err := f.net.Send(from, to, v); err != nil {
+			logf(f.Log, "P%d: %v\n", from, err)
+		}
+	})
+	f.timers[t] = true
+}
+
+// Close drops the delayed sends still pending, so none lands in an inbox
+// after the run is over
+func (f *FaultyTransport) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for t := range f.timers {
+		t.Stop()
+	}
+	f.timers = map[*time.Timer]bool{}
+}
+
+func (f *FaultyTransport) Inbox(id int) <-chan Delivery {
+	return f.net.Inbox(id)
+}
+
+// ParseLinks reads a list of links of the form "1-2,2-3", each standing
+// for the channels both ways between two processes
+func ParseLinks(s string) ([]Channel, error) {
+	var cs []Channel
+	for _, part := range strings.Split(s, ",") {
+		a, b, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("bad link %q, want i-j", part)
+		}
+		i, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad link %q: %v", part, err)
+		}
+		j, err := strconv.Atoi(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad link %q: %v", part, err)
+		}
+		cs = append(cs, Channel{From: i, To: j}, Channel{From: j, To: i})
+	}
+	return cs, nil
+}
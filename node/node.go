@@ -0,0 +1,163 @@
+// Package node is the network under a snapshot algorithm: a Node per
+// process with its neighbours, the Transport carrying values between them,
+// and the Topology saying who is linked to whom. Algorithms embed Node in
+// their own process type and handle what arrives in its event loop.
+package node
+
+import (
+	"context"
+	"fmt"
+)
+
+// Transport carries values over the directed channels between processes
+type Transport interface {
+	// Send puts v on the channel from -> to
+	Send(from, to int, v interface{}) error
+	// Inbox yields everything sent to process id, FIFO per channel
+	Inbox(id int) <-chan Delivery
+}
+
+// Logger is where processes and transports say what they do, such as a
+// *log.Logger. Leaving it nil keeps them quiet.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+func logf(l Logger, format string, args ...interface{}) {
+	if l != nil {
+		l.Printf(format, args...)
+	}
+}
+
+// Delivery is one value arriving at a process, tagged with its channel
+type Delivery struct {
+	From  int
+	Value interface{}
+}
+
+// Node is one process's view of the network: its ID, its neighbours and the
+// transport used to reach them. Algorithms embed it in their Process type.
+type Node struct {
+	ID   int
+	In   []int  // processes with a channel to this one
+	Out  []int  // processes this one has a channel to
+	Down bool   // crashed: whatever is delivered is lost
+	Log  Logger // where the process says what it does, if anywhere
+
+	net  Transport
+	cmds chan func() // work for the event loop, from other goroutines
+}
+
+// Send a value to neighbour `to`
+func (n *Node) Send(to int, v interface{}) error {
+	return n.net.Send(n.ID, to, v)
+}
+
+// Logf says what the process does, if it has a Log
+func (n *Node) Logf(format string, args ...interface{}) {
+	logf(n.Log, format, args...)
+}
+
+// Broadcast sends a value on every outgoing channel
+func (n *Node) Broadcast(v interface{}) error {
+	for _, to := range n.Out {
+		if err := n.net.Send(n.ID, to, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run passes each value arriving at the node to handle, blocking while
+// there is none, until ctx is cancelled or the inbox is closed. Functions
+// passed to Do run in between, so only this goroutine touches the process.
+func (n *Node) Run(ctx context.Context, handle func(from int, v interface{})) {
+	inbox := n.net.Inbox(n.ID)
+	for {
+		select {
+		case d, ok := <-inbox:
+			if !ok {
+				return
+			}
+			if !n.Down {
+				handle(d.From, d.Value)
+			}
+		case f := <-n.cmds:
+			f()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Do runs f on the node's event loop and waits for it to finish. Run must
+// be running; without it, as under the Scheduler, call f directly.
+func (n *Node) Do(f func()) {
+	done := make(chan struct{})
+	n.cmds <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// Pause holds the event loop between two deliveries until resume is called
+func (n *Node) Pause() (resume func()) {
+	paused, resumed := make(chan struct{}), make(chan struct{})
+	n.cmds <- func() {
+		close(paused)
+		<-resumed
+	}
+	<-paused
+	return func() { close(resumed) }
+}
+
+// ChanTransport carries values over one buffered Go channel per process,
+// shared by all its incoming channels
+type ChanTransport struct {
+	edges   map[Channel]bool
+	inboxes map[int]chan Delivery
+}
+
+// NewChanTransport creates an inbox for every process of the topology. It
+// holds as many values as its incoming channels together, each sized from
+// the topology or with the given default. The sizes only bound the
+// channels of a process together: a busy channel can use up the room a
+// quiet one leaves, so no single channel is held to its own size.
+func NewChanTransport(t Topology, buffer int) *ChanTransport {
+	c := &ChanTransport{edges: map[Channel]bool{}, inboxes: map[int]chan Delivery{}}
+	size := map[int]int{}
+	for _, e := range t.Edges {
+		c.edges[e] = true
+		if b, ok := t.Buffer[e]; ok {
+			size[e.To] += b
+		} else {
+			size[e.To] += buffer
+		}
+	}
+	for _, id := range t.Nodes {
+		c.inboxes[id] = make(chan Delivery, size[id])
+	}
+	return c
+}
+
+func (c *ChanTransport) Send(from, to int, v interface{}) error {
+	if !c.edges[Channel{From: from, To: to}] {
+		return fmt.Errorf("no channel P%d->P%d", from, to)
+	}
+	c.inboxes[to] <- Delivery{From: from, Value: v}
+	return nil
+}
+
+func (c *ChanTransport) Inbox(id int) <-chan Delivery {
+	return c.inboxes[id]
+}
+
+// Reset discards everything in flight
+func (c *ChanTransport) Reset() {
+	for _, ch := range c.inboxes {
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+}
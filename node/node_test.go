@@ -0,0 +1,42 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func BenchmarkChanTransport(b *testing.B) {
+	top := FullyConnected(1, 2)
+	benchmarkRun(b, top, NewChanTransport(top, 64))
+}
+
+func BenchmarkReliableTransport(b *testing.B) {
+	top := FullyConnected(1, 2)
+	r := NewReliableTransport(NewChanTransport(top, 64), top.Nodes, 20*time.Millisecond)
+	defer r.Close()
+	benchmarkRun(b, top, r)
+}
+
+// Push b.N messages from P1 to P2, each handled on P2's event loop
+func benchmarkRun(b *testing.B, top Topology, net Transport) {
+	nodes := top.Build(net)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	got := 0
+	go nodes[1].Run(ctx, func(from int, v interface{}) {})
+	go nodes[2].Run(ctx, func(from int, v interface{}) {
+		if got++; got == b.N {
+			close(done)
+		}
+	})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := nodes[1].Send(2, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,172 @@
+package node
+
+import (
+	"encoding/gob"
+	"sort"
+	"sync"
+	"time"
+)
+
+// segment carries one value with its sequence number on its channel
+type segment struct {
+	Seq     int
+	Payload interface{}
+}
+
+// ack tells the sender a segment arrived
+type ack struct {
+	Seq int
+}
+
+func init() {
+	gob.Register(segment{})
+	gob.Register(ack{})
+}
+
+// ReliableTransport restores reliable FIFO channels over a transport that
+// may lose, duplicate or reorder: values are numbered per channel, resent
+// until acknowledged, and delivered once each and in order. Acks travel on
+// the reverse channel, so the transport underneath needs one for every edge.
+type ReliableTransport struct {
+	Log Logger // where failed acks and resends are reported, if anywhere
+
+	net     Transport
+	inboxes map[int]chan Delivery
+	done    chan struct{}
+
+	mu      sync.Mutex
+	next    map[Channel]int                 // next sequence number to send
+	unacked map[Channel]map[int]interface{} // sent, not acknowledged yet
+	expect  map[Channel]int                 // next sequence number to deliver
+	held    map[Channel]map[int]interface{} // arrived ahead of expect
+}
+
+// NewReliableTransport serves the processes ids over net, resending what
+// is not acknowledged every interval, until Close
+func NewReliableTransport(net Transport, ids []int, interval time.Duration) *ReliableTransport {
+	r := &ReliableTransport{
+		net:     net,
+		inboxes: map[int]chan Delivery{},
+		done:    make(chan struct{}),
+		next:    map[Channel]int{},
+		unacked: map[Channel]map[int]interface{}{},
+		expect:  map[Channel]int{},
+		held:    map[Channel]map[int]interface{}{},
+	}
+	for _, id := range ids {
+		r.inboxes[id] = make(chan Delivery, 1024)
+	}
+	for _, id := range ids {
+		go r.pump(id)
+	}
+	go r.resend(interval)
+	return r
+}
+
+func (r *ReliableTransport) Send(from, to int, v interface{}) error {
+	c := Channel{From: from, To: to}
+	r.mu.Lock()
+	seq := r.next[c]
+	r.next[c]++
+	if r.unacked[c] == nil {
+		r.unacked[c] = map[int]interface{}{}
+	}
+	r.unacked[c][seq] = v
+	r.mu.Unlock()
+	return r.net.Send(from, to, segment{Seq: seq, Payload: v})
+}
+
+func (r *ReliableTransport) Inbox(id int) <-chan Delivery {
+	return r.inboxes[id]
+}
+
+// Close stops resending and delivering
+func (r *ReliableTransport) Close() {
+	close(r.done)
+}
+
+// pump takes what arrives for process id off the transport underneath,
+// acking segments and passing them on in order
+func (r *ReliableTransport) pump(id int) {
+	in := r.net.Inbox(id)
+	for {
+		var d Delivery
+		select {
+		case d = <-in:
+		case <-r.done:
+			return
+		}
+		switch m := d.Value.(type) {
+		case segment:
+			if err := r.net.Send(id, d.From, ack{Seq: m.Seq}); err != nil {
+				logf(r.Log, "P%d: %v\n", id, err)
+			}
+			for _, v := range r.arrive(Channel{From: d.From, To: id}, m) {
+				select {
+				case r.inboxes[id] <- Delivery{From: d.From, Value: v}:
+				case <-r.done:
+					return
+				}
+			}
+		case ack:
+			r.mu.Lock()
+			delete(r.unacked[Channel{From: id, To: d.From}], m.Seq)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// arrive records a segment on channel c and returns the values it makes
+// deliverable, none for a duplicate
+func (r *ReliableTransport) arrive(c Channel, m segment) []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.held[c] == nil {
+		r.held[c] = map[int]interface{}{}
+	}
+	if m.Seq >= r.expect[c] {
+		r.held[c][m.Seq] = m.Payload
+	}
+	var vs []interface{}
+	for {
+		v, ok := r.held[c][r.expect[c]]
+		if !ok {
+			return vs
+		}
+		delete(r.held[c], r.expect[c])
+		r.expect[c]++
+		vs = append(vs, v)
+	}
+}
+
+// resend everything unacknowledged, oldest first, every interval
+func (r *ReliableTransport) resend(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-r.done:
+			return
+		}
+		type pending struct {
+			c   Channel
+			seq int
+			v   interface{}
+		}
+		var ps []pending
+		r.mu.Lock()
+		for c, m := range r.unacked {
+			for seq, v := range m {
+				ps = append(ps, pending{c, seq, v})
+			}
+		}
+		r.mu.Unlock()
+		sort.Slice(ps, func(i, j int) bool { return ps[i].seq < ps[j].seq })
+		for _, p := range ps {
+			if err := r.net.Send(p.c.From, p.c.To, segment{Seq: p.seq, Payload: p.v}); err != nil {
+				logf(r.Log, "P%d: %v\n", p.c.From, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+// Over a network losing, duplicating and reordering messages, every value
+// still arrives once and in the order sent
+func TestReliableOverFaulty(t *testing.T) {
+	top := FullyConnected(1, 2)
+	faulty := NewFaultyTransport(NewChanTransport(top, 64),
+		Faults{Drop: 0.3, Duplicate: 0.3, Delay: 2 * time.Millisecond}, 1)
+	defer faulty.Close()
+	r := NewReliableTransport(faulty, top.Nodes, 5*time.Millisecond)
+	defer r.Close()
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		if err := r.Send(1, 2, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case d := <-r.Inbox(2):
+			if d.From != 1 || d.Value != i {
+				t.Fatalf("got %v from P%d, want %d from P1", d.Value, d.From, i)
+			}
+		case <-timeout:
+			t.Fatalf("only %d of %d values arrived", i, n)
+		}
+	}
+	select {
+	case d := <-r.Inbox(2):
+		t.Fatalf("got %v from P%d after the last value", d.Value, d.From)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,241 @@
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envelope is what goes over the wire; payload types must be gob.Register-ed
+type envelope struct {
+	From, To int
+	Payload  interface{}
+}
+
+// TCPTransport connects one local process to its peers over TCP. Each
+// outgoing channel is a single connection, so delivery on it is FIFO.
+type TCPTransport struct {
+	Log Logger // where stray messages are reported, if anywhere
+
+	id    int
+	peers map[int]string // process -> listen address
+	ln    net.Listener
+	inbox chan Delivery
+	done  chan struct{} // closed by Close
+
+	mu     sync.Mutex
+	links  map[int]*link     // dest -> outgoing connection
+	conns  map[net.Conn]bool // every open connection, both ways
+	closed bool
+}
+
+// link is the outgoing connection to one peer, dialled on first use and
+// again after it breaks. Its own lock keeps sends to the peer in order
+// without holding up sends to the others.
+type link struct {
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *gob.Encoder
+}
+
+// ListenTCP starts process id listening on its address from peers
+func ListenTCP(id int, peers map[int]string, buffer int) (*TCPTransport, error) {
+	addr, ok := peers[id]
+	if !ok {
+		return nil, fmt.Errorf("P%d is not in the peer list", id)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{
+		id:    id,
+		peers: peers,
+		ln:    ln,
+		inbox: make(chan Delivery, buffer),
+		done:  make(chan struct{}),
+		links: map[int]*link{},
+		conns: map[net.Conn]bool{},
+	}
+	go t.accept()
+	return t, nil
+}
+
+// accept incoming connections, one reader goroutine each
+func (t *TCPTransport) accept() {
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !t.track(conn) {
+			return
+		}
+		go t.read(conn)
+	}
+}
+
+// track a new connection for Close, closing it instead if that came first
+func (t *TCPTransport) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		conn.Close()
+		return false
+	}
+	t.conns[conn] = true
+	return true
+}
+
+// drop a connection that is done with
+func (t *TCPTransport) drop(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+	conn.Close()
+}
+
+// read envelopes off one connection into the inbox
+func (t *TCPTransport) read(conn net.Conn) {
+	defer t.drop(conn)
+	dec := gob.NewDecoder(conn)
+	for {
+		var e envelope
+		if err := dec.Decode(&e); err != nil {
+			return
+		}
+		if _, ok := t.peers[e.From]; !ok || e.To != t.id {
+			logf(t.Log, "P%d: dropping message for channel P%d->P%d\n", t.id, e.From, e.To)
+			continue
+		}
+		select {
+		case t.inbox <- Delivery{From: e.From, Value: e.Payload}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// dial the peer, retrying while it starts up
+func (t *TCPTransport) dial(to int) (net.Conn, error) {
+	addr := t.peers[to]
+	var conn net.Conn
+	var err error
+	for try := 0; try < 50; try++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-t.done:
+			return nil, fmt.Errorf("P%d is closed", t.id)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !t.track(conn) {
+		return nil, fmt.Errorf("P%d is closed", t.id)
+	}
+	return conn, nil
+}
+
+// link to process to, created on first use
+func (t *TCPTransport) link(to int) (*link, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, fmt.Errorf("P%d is closed", t.id)
+	}
+	if _, ok := t.peers[to]; !ok {
+		return nil, fmt.Errorf("no address for P%d", to)
+	}
+	l, ok := t.links[to]
+	if !ok {
+		l = &link{}
+		t.links[to] = l
+	}
+	return l, nil
+}
+
+func (t *TCPTransport) Send(from, to int, v interface{}) error {
+	if from != t.id {
+		return fmt.Errorf("P%d cannot send on behalf of P%d", t.id, from)
+	}
+	l, err := t.link(to)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc == nil {
+		conn, err := t.dial(to)
+		if err != nil {
+			return err
+		}
+		l.conn, l.enc = conn, gob.NewEncoder(conn)
+	}
+	if err := l.enc.Encode(envelope{From: from, To: to, Payload: v}); err != nil {
+		// the encoder is broken with its connection, the next send dials again
+		t.drop(l.conn)
+		l.conn, l.enc = nil, nil
+		return err
+	}
+	return nil
+}
+
+// Inbox yields what the peers send to the local process. Connections
+// deliver concurrently, but each one in order.
+func (t *TCPTransport) Inbox(id int) <-chan Delivery {
+	if id != t.id {
+		return nil
+	}
+	return t.inbox
+}
+
+// Close stops listening and closes every connection, outgoing and accepted
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	for c := range t.conns {
+		c.Close()
+	}
+	return t.ln.Close()
+}
+
+// ParsePeers reads a peer list of the form "1=host:port,2=host:port"
+func ParsePeers(s string) (map[int]string, error) {
+	peers := map[int]string{}
+	for _, part := range strings.Split(s, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("bad peer %q, want id=host:port", part)
+		}
+		pid, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("bad peer id %q", id)
+		}
+		peers[pid] = addr
+	}
+	return peers, nil
+}
+
+// PeerIDs returns the process IDs of a peer list in order
+func PeerIDs(peers map[int]string) []int {
+	var ids []int
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
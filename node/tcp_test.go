@@ -0,0 +1,83 @@
+package node_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"snapshot/algorithm/chandylamport"
+	"snapshot/algorithm/laiyang"
+	"snapshot/node"
+)
+
+// Two processes on loopback addresses, each listening for the other
+func listenPair(t *testing.T) (*node.TCPTransport, *node.TCPTransport) {
+	peers := map[int]string{}
+	for _, id := range []int{1, 2} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers[id] = ln.Addr().String()
+		ln.Close()
+	}
+	var ts []*node.TCPTransport
+	for _, id := range []int{1, 2} {
+		tr, err := node.ListenTCP(id, peers, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { tr.Close() })
+		ts = append(ts, tr)
+	}
+	return ts[0], ts[1]
+}
+
+func TestTCPTransportFIFO(t *testing.T) {
+	t1, t2 := listenPair(t)
+	var sent []interface{}
+	for i := 1; i <= 50; i++ {
+		switch i % 3 {
+		case 0:
+			sent = append(sent, chandylamport.Message{From: 1, Seq: i, Data: "m", Clock: i})
+		case 1:
+			sent = append(sent, chandylamport.Marker{Initiator: 1, Snapshot: i, Clock: i})
+		case 2:
+			sent = append(sent, laiyang.LYMessage{From: 1, Seq: i, Data: "ly", Epoch: i, Clock: i})
+		}
+	}
+	for _, v := range sent {
+		if err := t1.Send(1, 2, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range sent {
+		select {
+		case d := <-t2.Inbox(2):
+			if d.From != 1 || !reflect.DeepEqual(d.Value, want) {
+				t.Fatalf("delivery %d: got %#v from P%d, want %#v from P1", i, d.Value, d.From, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivery %d never arrived", i)
+		}
+	}
+}
+
+func TestTCPTransportClosed(t *testing.T) {
+	t1, t2 := listenPair(t)
+	if err := t1.Send(1, 2, chandylamport.Marker{Snapshot: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-t2.Inbox(2)
+	if err := t1.Send(2, 1, chandylamport.Marker{}); err == nil {
+		t.Error("sent on behalf of another process")
+	}
+	if err := t1.Send(1, 3, chandylamport.Marker{}); err == nil {
+		t.Error("sent to a process missing from the peer list")
+	}
+	t1.Close()
+	if err := t1.Send(1, 2, chandylamport.Marker{}); err == nil {
+		t.Error("sent after Close")
+	}
+}
@@ -0,0 +1,282 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// Channel identifies the directed channel From -> To
+type Channel struct {
+	From, To int
+}
+
+// Topology lists the processes and the directed channels between them
+type Topology struct {
+	Nodes  []int
+	Edges  []Channel
+	State  map[int]string  // initial application state per process, "init<id>" when missing
+	Buffer map[Channel]int // buffer size per channel, transport default when missing, see NewChanTransport
+}
+
+// InitialState of process id
+func (t Topology) InitialState(id int) string {
+	if s, ok := t.State[id]; ok {
+		return s
+	}
+	return fmt.Sprintf("init%d", id)
+}
+
+// FullyConnected returns the topology with a channel each way between every pair
+func FullyConnected(ids ...int) Topology {
+	t := Topology{Nodes: append([]int{}, ids...)}
+	for _, from := range ids {
+		for _, to := range ids {
+			if from != to {
+				t.Edges = append(t.Edges, Channel{From: from, To: to})
+			}
+		}
+	}
+	return t
+}
+
+// Ring returns processes 1..n with a channel from each to the next, n back to 1
+func Ring(n int) Topology {
+	t := Topology{Nodes: seq(n)}
+	for i := 1; i <= n; i++ {
+		t.Edges = append(t.Edges, Channel{From: i, To: i%n + 1})
+	}
+	return t
+}
+
+// Star returns process 1 linked both ways to each of processes 2..n
+func Star(n int) Topology {
+	t := Topology{Nodes: seq(n)}
+	for i := 2; i <= n; i++ {
+		t.Edges = append(t.Edges, Channel{From: 1, To: i}, Channel{From: i, To: 1})
+	}
+	return t
+}
+
+// Line returns processes 1..n with each linked both ways to the next
+func Line(n int) Topology {
+	t := Topology{Nodes: seq(n)}
+	for i := 1; i < n; i++ {
+		t.Edges = append(t.Edges, Channel{From: i, To: i + 1}, Channel{From: i + 1, To: i})
+	}
+	return t
+}
+
+// Random returns a strongly connected graph on processes 1..n: a directed
+// cycle through all of them in random order plus `extra` random channels
+func Random(n, extra int, seed int64) Topology {
+	rng := rand.New(rand.NewSource(seed))
+	t := Topology{Nodes: seq(n)}
+	have := map[Channel]bool{}
+	add := func(e Channel) {
+		if e.From != e.To && !have[e] {
+			have[e] = true
+			t.Edges = append(t.Edges, e)
+		}
+	}
+	order := rng.Perm(n)
+	for i := range order {
+		add(Channel{From: order[i] + 1, To: order[(i+1)%n] + 1})
+	}
+	for i := 0; i < extra && len(t.Edges) < n*(n-1); {
+		before := len(t.Edges)
+		add(Channel{From: rng.Intn(n) + 1, To: rng.Intn(n) + 1})
+		if len(t.Edges) > before {
+			i++
+		}
+	}
+	return t
+}
+
+func seq(n int) []int {
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	return ids
+}
+
+// Validate checks that channels join known, distinct processes at most once
+// and that the graph is strongly connected, so a marker sent by any process
+// reaches every other one
+func (t Topology) Validate() error {
+	if len(t.Nodes) == 0 {
+		return fmt.Errorf("topology has no processes")
+	}
+	known := map[int]bool{}
+	for _, id := range t.Nodes {
+		if known[id] {
+			return fmt.Errorf("process %d listed twice", id)
+		}
+		known[id] = true
+	}
+	out := map[int][]int{}
+	in := map[int][]int{}
+	seen := map[Channel]bool{}
+	for _, e := range t.Edges {
+		switch {
+		case !known[e.From] || !known[e.To]:
+			return fmt.Errorf("channel P%d->P%d joins an unknown process", e.From, e.To)
+		case e.From == e.To:
+			return fmt.Errorf("channel P%d->P%d is a self-loop", e.From, e.To)
+		case seen[e]:
+			return fmt.Errorf("channel P%d->P%d listed twice", e.From, e.To)
+		}
+		seen[e] = true
+		out[e.From] = append(out[e.From], e.To)
+		in[e.To] = append(in[e.To], e.From)
+	}
+
+	// everyone reachable from the first process, and the first reachable from everyone
+	for dir, adj := range []map[int][]int{out, in} {
+		reached := map[int]bool{t.Nodes[0]: true}
+		queue := []int{t.Nodes[0]}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, next := range adj[id] {
+				if !reached[next] {
+					reached[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		for _, id := range t.Nodes {
+			from, to := t.Nodes[0], id
+			if dir == 1 {
+				from, to = id, t.Nodes[0]
+			}
+			if !reached[id] {
+				return fmt.Errorf("topology is not strongly connected: P%d cannot reach P%d", from, to)
+			}
+		}
+	}
+	return nil
+}
+
+// topologyFile is the JSON form of a topology. Either list nodes and edges
+// explicitly, or name a shape to generate:
+//
+//	{"shape": "ring", "size": 5, "buffer": 10}
+//	{"nodes": [{"id": 1, "state": "a"}, {"id": 2}], "edges": [{"from": 1, "to": 2, "buffer": 4}, {"from": 2, "to": 1}]}
+type topologyFile struct {
+	Shape  string `json:"shape"` // full, ring, star, line or random
+	Size   int    `json:"size"`
+	Extra  int    `json:"extra"` // random: channels added on top of the cycle
+	Seed   int64  `json:"seed"`  // random: generator seed
+	Buffer int    `json:"buffer"`
+	Nodes  []struct {
+		ID    int    `json:"id"`
+		State string `json:"state"`
+	} `json:"nodes"`
+	Edges []struct {
+		From   int `json:"from"`
+		To     int `json:"to"`
+		Buffer int `json:"buffer"`
+	} `json:"edges"`
+}
+
+// LoadTopology reads and validates a topology from a JSON file
+func LoadTopology(path string) (Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Topology{}, err
+	}
+	var f topologyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Topology{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var t Topology
+	if f.Shape != "" {
+		shapes := map[string]func(n int) Topology{
+			"full":   func(n int) Topology { return FullyConnected(seq(n)...) },
+			"ring":   Ring,
+			"star":   Star,
+			"line":   Line,
+			"random": func(n int) Topology { return Random(n, f.Extra, f.Seed) },
+		}
+		shape, ok := shapes[f.Shape]
+		switch {
+		case !ok:
+			return Topology{}, fmt.Errorf("%s: unknown shape %q", path, f.Shape)
+		case f.Size < 2:
+			// a ring of one would be a self-loop, the others a lone process
+			return Topology{}, fmt.Errorf("%s: shape %q needs a size of at least 2", path, f.Shape)
+		}
+		t = shape(f.Size)
+	}
+
+	t.State = map[int]string{}
+	t.Buffer = map[Channel]int{}
+	for _, n := range f.Nodes {
+		if f.Shape == "" {
+			t.Nodes = append(t.Nodes, n.ID)
+		}
+		if n.State != "" {
+			t.State[n.ID] = n.State
+		}
+	}
+	for _, e := range f.Edges {
+		c := Channel{From: e.From, To: e.To}
+		if f.Shape == "" {
+			t.Edges = append(t.Edges, c)
+		}
+		if e.Buffer > 0 {
+			t.Buffer[c] = e.Buffer
+		}
+	}
+	if f.Buffer > 0 {
+		for _, e := range t.Edges {
+			if _, ok := t.Buffer[e]; !ok {
+				t.Buffer[e] = f.Buffer
+			}
+		}
+	}
+	if err := t.Validate(); err != nil {
+		return Topology{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Bidirectional returns the topology with the reverse of every channel
+// added where it is missing
+func (t Topology) Bidirectional() Topology {
+	have := map[Channel]bool{}
+	for _, e := range t.Edges {
+		have[e] = true
+	}
+	b := t
+	b.Edges = append([]Channel{}, t.Edges...)
+	for _, e := range t.Edges {
+		if r := (Channel{From: e.To, To: e.From}); !have[r] {
+			have[r] = true
+			b.Edges = append(b.Edges, r)
+		}
+	}
+	return b
+}
+
+// Build creates one Node per process, wired to its neighbours over net
+func (t Topology) Build(net Transport) map[int]*Node {
+	nodes := map[int]*Node{}
+	for _, id := range t.Nodes {
+		nodes[id] = &Node{ID: id, net: net, cmds: make(chan func())}
+	}
+	for _, e := range t.Edges {
+		nodes[e.From].Out = append(nodes[e.From].Out, e.To)
+		nodes[e.To].In = append(nodes[e.To].In, e.From)
+	}
+	for _, n := range nodes {
+		sort.Ints(n.In)
+		sort.Ints(n.Out)
+	}
+	return nodes
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Load a topology from a file holding the JSON given
+func load(t *testing.T, js string) (Topology, error) {
+	path := filepath.Join(t.TempDir(), "top.json")
+	if err := os.WriteFile(path, []byte(js), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadTopology(path)
+}
+
+func TestLoadTopology(t *testing.T) {
+	top, err := load(t, `{"nodes": [{"id": 1, "state": "a"}, {"id": 2}],
+		"edges": [{"from": 1, "to": 2, "buffer": 4}, {"from": 2, "to": 1}], "buffer": 10}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Topology{
+		Nodes:  []int{1, 2},
+		Edges:  []Channel{{1, 2}, {2, 1}},
+		State:  map[int]string{1: "a"},
+		Buffer: map[Channel]int{{1, 2}: 4, {2, 1}: 10},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("got %+v, want %+v", top, want)
+	}
+
+	ring, err := load(t, `{"shape": "ring", "size": 3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Ring(3); !reflect.DeepEqual(ring.Edges, want.Edges) {
+		t.Fatalf("got channels %v, want %v", ring.Edges, want.Edges)
+	}
+}
+
+func TestLoadTopologyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		js   string
+		want string // in the error
+	}{
+		{"bad JSON", `{"shape": "ring",}`, "invalid character"},
+		{"unknown shape", `{"shape": "torus", "size": 4}`, `unknown shape "torus"`},
+		{"negative size", `{"shape": "ring", "size": -2}`, `shape "ring" needs a size of at least 2`},
+		{"ring of one", `{"shape": "ring", "size": 1}`, `shape "ring" needs a size of at least 2`},
+		{"no size", `{"shape": "star"}`, `shape "star" needs a size of at least 2`},
+		{"no processes", `{}`, "topology has no processes"},
+		{"process twice", `{"nodes": [{"id": 1}, {"id": 1}]}`, "process 1 listed twice"},
+		{"unknown process", `{"nodes": [{"id": 1}, {"id": 2}], "edges": [{"from": 1, "to": 3}]}`,
+			"channel P1->P3 joins an unknown process"},
+		{"self-loop", `{"nodes": [{"id": 1}], "edges": [{"from": 1, "to": 1}]}`, "channel P1->P1 is a self-loop"},
+		{"channel twice", `{"nodes": [{"id": 1}, {"id": 2}], "edges": [{"from": 1, "to": 2}, {"from": 1, "to": 2}]}`,
+			"channel P1->P2 listed twice"},
+		{"one way", `{"nodes": [{"id": 1}, {"id": 2}], "edges": [{"from": 1, "to": 2}]}`,
+			"not strongly connected: P2 cannot reach P1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.js)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
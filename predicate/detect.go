@@ -0,0 +1,60 @@
+package predicate
+
+import (
+	"fmt"
+	"io"
+)
+
+// Detector keeps taking snapshots until one of them finds a stable
+// predicate holding, so that it holds from that cut on. It is probed from
+// outside, every so often, and never waits for a snapshot itself.
+type Detector struct {
+	Pred     Predicate
+	Initiate func() int               // starts a snapshot, returning its ID or 0 if none was started
+	Collect  func(id int) (Cut, bool) // the cut snapshot id recorded, false while incomplete
+	Max      int                      // snapshots to take before giving up
+	Out      io.Writer                // where each verdict goes, if anywhere
+
+	Taken   int // snapshots taken so far
+	Pending int // snapshot being taken, 0 for none
+	Found   int // snapshot the predicate held at, 0 until then
+}
+
+// Over reports whether detection is over, found or given up
+func (d *Detector) Over() bool {
+	return d.Found != 0 || d.Pending == 0 && d.Taken == d.Max
+}
+
+// Probe evaluates the snapshot being taken once it is complete, and takes
+// the next one if need be. Reports whether detection is over.
+func (d *Detector) Probe() bool {
+	if d.Found != 0 {
+		return true
+	}
+	if d.Pending != 0 {
+		c, ok := d.Collect(d.Pending)
+		if !ok {
+			return false
+		}
+		id := d.Pending
+		d.Pending = 0
+		if d.Pred.Holds(c) {
+			d.Found = id
+			d.printf("\n%s holds at snapshot #%d\n", d.Pred.Name, id)
+			return true
+		}
+		d.printf("\n%s does not hold at snapshot #%d\n", d.Pred.Name, id)
+	}
+	if d.Taken == d.Max {
+		return true
+	}
+	d.Taken++
+	d.Pending = d.Initiate()
+	return false
+}
+
+func (d *Detector) printf(format string, args ...interface{}) {
+	if d.Out != nil {
+		fmt.Fprintf(d.Out, format, args...)
+	}
+}
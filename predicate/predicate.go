@@ -0,0 +1,131 @@
+// Package predicate is properties of the global states snapshots record,
+// stable ones in particular, which stay true once a snapshot finds them.
+package predicate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"snapshot/app"
+	"snapshot/node"
+)
+
+// Cut is the global state a snapshot recorded, whichever algorithm took it
+type Cut struct {
+	States    map[int]string            // process -> recorded application state
+	InTransit map[node.Channel][]string // channel -> data of the messages recorded in transit on it
+}
+
+// Money is what the cut holds under the bank workload: the recorded
+// balances plus the transfers recorded in transit
+func (c Cut) Money() (int, error) {
+	sum := 0
+	for pid, state := range c.States {
+		n, err := app.Balance(state)
+		if err != nil {
+			return 0, fmt.Errorf("P%d: %v", pid, err)
+		}
+		sum += n
+	}
+	for ch, msgs := range c.InTransit {
+		for _, data := range msgs {
+			n, err := app.Amount(data)
+			if err != nil {
+				return 0, fmt.Errorf("P%d->P%d: %v", ch.From, ch.To, err)
+			}
+			sum += n
+		}
+	}
+	return sum, nil
+}
+
+// Predicate is a property of global states. Stable ones stay true once they
+// hold, so a snapshot finding one true shows it holds from then on, which
+// is what detecting predicates with snapshots relies on.
+type Predicate struct {
+	Name  string
+	Holds func(c Cut) bool
+}
+
+// NoneInTransit holds when no message is in transit
+func NoneInTransit() Predicate {
+	return Predicate{Name: "empty", Holds: func(c Cut) bool {
+		for _, msgs := range c.InTransit {
+			if len(msgs) > 0 {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+// AllIdle holds when every process was idle, as idle tells from the state
+// the process recorded
+func AllIdle(idle func(pid int, state string) bool) Predicate {
+	return Predicate{Name: "idle", Holds: func(c Cut) bool {
+		for pid, state := range c.States {
+			if !idle(pid, state) {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+// TotalBalance holds when the balances of the bank workload and the
+// transfers in transit add up to total
+func TotalBalance(total int) Predicate {
+	return Predicate{Name: fmt.Sprintf("total=%d", total), Holds: func(c Cut) bool {
+		sum, err := c.Money()
+		return err == nil && sum == total
+	}}
+}
+
+// And holds when all of ps hold
+func And(ps ...Predicate) Predicate {
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	return Predicate{Name: strings.Join(names, ","), Holds: func(c Cut) bool {
+		for _, p := range ps {
+			if !p.Holds(c) {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+// Parse reads a predicate: empty (no message in transit), idle
+// (every process idle), quiet (both, so the computation has terminated) or
+// total=N, or several of them joined by commas to hold together
+func Parse(s string, idle func(pid int, state string) bool) (Predicate, error) {
+	var ps []Predicate
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "empty":
+			ps = append(ps, NoneInTransit())
+		case part == "idle":
+			ps = append(ps, AllIdle(idle))
+		case part == "quiet":
+			q := And(NoneInTransit(), AllIdle(idle))
+			q.Name = "quiet"
+			ps = append(ps, q)
+		case strings.HasPrefix(part, "total="):
+			n, err := strconv.Atoi(strings.TrimPrefix(part, "total="))
+			if err != nil {
+				return Predicate{}, fmt.Errorf("bad predicate %q: %v", part, err)
+			}
+			ps = append(ps, TotalBalance(n))
+		default:
+			return Predicate{}, fmt.Errorf("unknown predicate %q, want empty, idle, quiet or total=N", part)
+		}
+	}
+	if len(ps) == 1 {
+		return ps[0], nil
+	}
+	return And(ps...), nil
+}
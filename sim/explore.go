@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"fmt"
+
+	"snapshot/node"
+)
+
+// Explorer runs a scenario under every message delivery interleaving, one
+// fresh system per interleaving. Deliveries to different processes commute,
+// so sleep sets prune interleavings that only reorder those.
+type Explorer struct {
+	Top      node.Topology
+	Scenario Scenario
+	// Setup builds a fresh system on sched. It returns how to perform a
+	// scenario action and a check of the finished run.
+	Setup   func(sched *Scheduler) (do func(Action), check func() error)
+	MaxRuns int // 0 for no limit
+
+	frames []*frame
+}
+
+// frame is one delivery choice along the current interleaving
+type frame struct {
+	enabled []node.Channel
+	sleep   map[node.Channel]bool // choices known to lead to explored interleavings
+	done    []node.Channel        // choices taken from here, the last one is current
+}
+
+// Failure is an interleaving whose run did not pass the check
+type Failure struct {
+	Schedule []node.Channel
+	Err      error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("schedule %v: %v", f.Schedule, f.Err)
+}
+
+// Explore returns the number of interleavings run and pruned, and a
+// *Failure for the first one failing its check
+func (e *Explorer) Explore() (runs, pruned int, err error) {
+	e.frames = nil
+	for {
+		if e.MaxRuns > 0 && runs >= e.MaxRuns {
+			return runs, pruned, fmt.Errorf("stopped after %d runs", runs)
+		}
+		sched, check, blocked := e.replay()
+		if blocked {
+			pruned++
+		} else {
+			runs++
+			if err := check(); err != nil {
+				return runs, pruned, &Failure{Schedule: sched.Schedule, Err: err}
+			}
+		}
+		if !e.backtrack() {
+			return runs, pruned, nil
+		}
+	}
+}
+
+// run one interleaving: follow the current frames, then extend them by
+// taking the first choice not asleep. blocked means every choice at some
+// point was asleep, so the run repeats an explored interleaving and is
+// stopped there.
+func (e *Explorer) replay() (sched *Scheduler, check func() error, blocked bool) {
+	sched = NewScheduler(e.Top, 0)
+	do, check := e.Setup(sched)
+
+	depth := 0
+	acted := false // scenario actions ran since the last choice
+	sched.Choose = func(enabled []node.Channel) int {
+		defer func() { depth++; acted = false }()
+		if depth < len(e.frames) {
+			return indexOf(enabled, e.frames[depth].current())
+		}
+
+		f := &frame{enabled: enabled, sleep: map[node.Channel]bool{}}
+		if depth > 0 && !acted {
+			// choices skipped at the parent stay asleep if they commute with its choice
+			parent := e.frames[depth-1]
+			u := parent.current()
+			asleep := append([]node.Channel{}, parent.done[:len(parent.done)-1]...)
+			for t := range parent.sleep {
+				asleep = append(asleep, t)
+			}
+			for _, t := range asleep {
+				if t.To != u.To {
+					f.sleep[t] = true
+				}
+			}
+		}
+		for _, c := range enabled {
+			if !f.sleep[c] {
+				f.done = append(f.done, c)
+				e.frames = append(e.frames, f)
+				return indexOf(enabled, c)
+			}
+		}
+		blocked = true
+		sched.Stop()
+		return 0
+	}
+	sched.Run(e.Scenario, func(a Action) {
+		acted = true
+		do(a)
+	})
+	return sched, check, blocked
+}
+
+// move to the next unexplored choice, deepest first
+func (e *Explorer) backtrack() bool {
+	for len(e.frames) > 0 {
+		f := e.frames[len(e.frames)-1]
+		for _, c := range f.enabled {
+			if !f.sleep[c] && indexOf(f.done, c) < 0 {
+				f.done = append(f.done, c)
+				return true
+			}
+		}
+		e.frames = e.frames[:len(e.frames)-1]
+	}
+	return false
+}
+
+func (f *frame) current() node.Channel {
+	return f.done[len(f.done)-1]
+}
+
+func indexOf(cs []node.Channel, c node.Channel) int {
+	for i := range cs {
+		if cs[i] == c {
+			return i
+		}
+	}
+	return -1
+}
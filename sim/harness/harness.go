@@ -0,0 +1,106 @@
+// Package harness runs a snapshot algorithm the way the programs do:
+// simulated in one binary, in real time or deterministically under a seed,
+// explored under every interleaving, or as one process of many over TCP,
+// checking every snapshot taken is a consistent cut of the run. An
+// algorithm plugs in through the small Process interface, and its main
+// only registers it:
+//
+//	func main() {
+//		harness.Algorithm[chandylamport.Message, *chandylamport.Process]{...}.Main()
+//	}
+package harness
+
+import (
+	"io"
+	"time"
+
+	"snapshot/app"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/spacetime"
+	"snapshot/trace"
+)
+
+// Process is one participant of a snapshot algorithm on its node, whose
+// application messages are of type M
+type Process[M collect.Message] interface {
+	// App is the application the process runs
+	App() app.Application
+	// SendMessage sends an application message on the channel to process to
+	SendMessage(to int, data string)
+	// Initiate starts a snapshot from the process, returning its ID
+	Initiate() int
+	// Receive handles one message delivered on the channel from process from
+	Receive(from int, v interface{})
+	// LastSnapshot is the highest snapshot ID the process has seen
+	LastSnapshot() int
+	// Restore rolls back to a checkpointed local snapshot, with no
+	// snapshot in progress and new IDs continuing after last
+	Restore(s collect.Local[M], last int)
+	// Resend puts a message recorded in transit back on the channel to
+	// process to after a rollback, tracing it as sent
+	Resend(to int, m M) error
+}
+
+// TokenRing is a Process that can also detect termination with Safra's
+// token, passed around a ring of the processes
+type TokenRing interface {
+	// JoinRing passes the token on to process next, the initiator
+	// starting the rounds. It must come before the first message.
+	JoinRing(next int, initiator bool)
+	// DetectTermination starts the rounds at the initiator
+	DetectTermination()
+	// Detected reports whether the initiator detected termination, after
+	// how many rounds, and how many tokens the process sent
+	Detected() (terminated bool, rounds, tokens int)
+}
+
+// Algorithm is a snapshot algorithm the harness runs: how to create its
+// processes, and how its runs look
+type Algorithm[M collect.Message, P Process[M]] struct {
+	Name string // as in "P1 initiates <Name> snapshot"
+	Demo string // scenario run on the triangle when given none, see sim.ParseScenario
+
+	// New runs the algorithm on node n for application a, tracing to t if
+	// not nil and handing every completed local snapshot to report
+	New func(n *node.Node, a app.Application, t *trace.Trace, report func(collect.Local[M])) P
+
+	Diagram  spacetime.Style // how snapshots are drawn
+	Controls string          // what its control messages are called, as in "12 markers"
+}
+
+// Config is how to run the in-memory simulation
+type Config struct {
+	Tick      time.Duration
+	Seed      int64 // -1 for a real-time run
+	Reorder   float64
+	Window    int
+	Faults    node.Faults
+	FaultSeed int64
+	Reliable  bool
+
+	Checkpoints string // directory for checkpoints, needed by crash scenarios
+	Workload    app.Workload
+	Money       int        // total of the bank workload, conserved by every snapshot
+	Sink        trace.Sink // where traced events go as they happen, if anywhere
+	Diagram     string     // where to draw each snapshot, see spacetime.WriteDiagram
+
+	Until  *predicate.Predicate // stable predicate to detect, if any
+	Every  int                  // scenario steps between the detector's snapshots
+	Probes int                  // snapshots the detector takes before giving up
+
+	Termination string // detect the computation terminating by snapshot, safra or both, "" for not at all
+
+	Log node.Logger // where the processes and the run say what they do, nil for nowhere
+	Out io.Writer   // where the snapshots and the outcome go, nil for nowhere
+}
+
+// Result is the outcome of a simulated run
+type Result struct {
+	Good, Total int // snapshots that are consistent, out of those taken
+
+	// With Termination set, the first step of a deterministic run by which
+	// the computation terminated and each detector saw it, 0 for never
+	Ended, BySnapshot, BySafra int
+}
@@ -0,0 +1,299 @@
+package harness
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"snapshot/algorithm/chandylamport"
+	"snapshot/algorithm/laiyang"
+	"snapshot/app"
+	"snapshot/checkpoint"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/sim"
+)
+
+// The algorithms as their programs register them
+var (
+	chandyLamport = Algorithm[chandylamport.Message, *chandylamport.Process]{
+		Name:     "Chandy-Lamport",
+		New:      chandylamport.NewProcess,
+		Diagram:  chandylamport.Diagram,
+		Controls: "markers",
+	}
+	laiYang = Algorithm[laiyang.LYMessage, *laiyang.Process]{
+		Name:     "Lai-Yang",
+		New:      laiyang.NewProcess,
+		Diagram:  laiyang.Diagram,
+		Controls: "control messages",
+	}
+)
+
+// fixture is a scenario to check on a topology, with the workload it runs
+type fixture struct {
+	name string
+	top  node.Topology
+	sc   sim.Scenario
+	cfg  Config
+}
+
+// The scenarios under scenarios/ on the triangle they are written for, and
+// a generated scenario on every topology under topologies/
+func fixtures(t *testing.T) []fixture {
+	triangle := node.FullyConnected(1, 2, 3)
+	log := Config{Workload: app.LogWorkload}
+	var fs []fixture
+
+	scenarios, err := filepath.Glob("../../scenarios/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range scenarios {
+		sc, err := sim.LoadScenario(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fixture{strings.TrimSuffix(filepath.Base(path), ".txt"), triangle, sc, log}
+		if f.name == "bank" {
+			f.cfg = Config{Workload: app.BankWorkload(100), Money: app.BankTotal(triangle, 100)}
+		}
+		if sc.HasCrashes() {
+			f.cfg.Checkpoints = t.TempDir()
+		}
+		fs = append(fs, f)
+	}
+
+	topologies, err := filepath.Glob("../../topologies/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range topologies {
+		top, err := node.LoadTopology(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs = append(fs, fixture{filepath.Base(path), top, sim.GenerateScenario(top), log})
+	}
+
+	for _, f := range fs {
+		if err := f.sc.Validate(f.top); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+	}
+	return fs
+}
+
+// The fixture of the given name
+func fixtureNamed(t *testing.T, name string) fixture {
+	for _, f := range fixtures(t) {
+		if f.name == name {
+			return f
+		}
+	}
+	t.Fatalf("no fixture %s", name)
+	return fixture{}
+}
+
+// Run a fixture under the scheduler with the given seed, as -seed does
+func seeded[M collect.Message, P Process[M]](a Algorithm[M, P], f fixture, seed int64, reorder float64) *system[M, P] {
+	sched := sim.NewScheduler(f.top, seed)
+	sched.Reorder, sched.Window = reorder, 3
+	if f.cfg.Checkpoints != "" {
+		if err := checkpoint.Clear(f.cfg.Checkpoints); err != nil {
+			panic(err)
+		}
+	}
+	sys := a.newSystem(f.top, sched, f.cfg)
+	sys.attach(sched)
+	sched.Run(f.sc, sys.do)
+	return sys
+}
+
+func TestSeeded(t *testing.T) {
+	testSeeded(t, chandyLamport)
+	testSeeded(t, laiYang)
+}
+
+func testSeeded[M collect.Message, P Process[M]](t *testing.T, a Algorithm[M, P]) {
+	for _, f := range fixtures(t) {
+		t.Run(a.Name+"/"+f.name, func(t *testing.T) {
+			for seed := int64(0); seed < 20; seed++ {
+				sys := seeded(a, f, seed, 0)
+				if len(sys.ids) == 0 {
+					t.Fatalf("seed %d: no snapshot taken", seed)
+				}
+				if err := sys.check(); err != nil {
+					t.Errorf("seed %d: %v", seed, err)
+				}
+			}
+		})
+	}
+}
+
+// The same seed gives the same run, delivery for delivery and event for
+// event, while another seed can give another
+func TestDeterministic(t *testing.T) {
+	testDeterministic(t, chandyLamport)
+	testDeterministic(t, laiYang)
+}
+
+func testDeterministic[M collect.Message, P Process[M]](t *testing.T, a Algorithm[M, P]) {
+	for _, f := range fixtures(t) {
+		t.Run(a.Name+"/"+f.name, func(t *testing.T) {
+			schedule := func(sys *system[M, P]) []node.Channel {
+				return sys.net.(*sim.Scheduler).Schedule
+			}
+			first, again := seeded(a, f, 7, 0), seeded(a, f, 7, 0)
+			if !reflect.DeepEqual(schedule(first), schedule(again)) {
+				t.Fatalf("seed 7 delivered in two orders:\n%v\n%v", schedule(first), schedule(again))
+			}
+			if !reflect.DeepEqual(first.trace.Events(), again.trace.Events()) {
+				t.Fatal("seed 7 traced two runs")
+			}
+			for seed := int64(0); seed < 20; seed++ {
+				if !reflect.DeepEqual(schedule(first), schedule(seeded(a, f, seed, 0))) {
+					return
+				}
+			}
+			t.Fatal("every seed delivers in the same order")
+		})
+	}
+}
+
+// The fixtures small enough to explore every interleaving of, a few
+// thousand runs at most. The others run into the cap.
+var explorable = []string{"small", "bank", "ring.json", "line.json"}
+
+func TestExplore(t *testing.T) {
+	testExplore(t, chandyLamport)
+	testExplore(t, laiYang)
+}
+
+func testExplore[M collect.Message, P Process[M]](t *testing.T, a Algorithm[M, P]) {
+	for _, name := range explorable {
+		f := fixtureNamed(t, name)
+		t.Run(a.Name+"/"+f.name, func(t *testing.T) {
+			runs, _, err := a.Explore(f.top, f.sc, f.cfg, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runs == 0 {
+				t.Fatal("no interleaving explored")
+			}
+		})
+	}
+}
+
+// Chandy-Lamport needs FIFO channels: once messages may overtake markers,
+// some run records a cut that Verify rejects
+func TestReorderBreaksChandyLamport(t *testing.T) {
+	f := fixtureNamed(t, "concurrent")
+	for seed := int64(0); seed < 50; seed++ {
+		sys := seeded(chandyLamport, f, seed, 0.5)
+		for _, tk := range sys.snapshots(0) {
+			if err := tk.g.Verify(tk.events); err != nil {
+				t.Logf("seed %d, snapshot #%d: %v", seed, tk.g.ID, err)
+				return
+			}
+		}
+	}
+	t.Fatal("every cut is consistent despite reordering")
+}
+
+// Lai-Yang needs no FIFO channels: with messages overtaking each other
+// every cut stays consistent
+func TestReorderKeepsLaiYang(t *testing.T) {
+	for _, f := range fixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			for seed := int64(0); seed < 20; seed++ {
+				if err := seeded(laiYang, f, seed, 0.5).check(); err != nil {
+					t.Errorf("seed %d: %v", seed, err)
+				}
+			}
+		})
+	}
+}
+
+// Under a seed the jobs run out, and each detector sees it no earlier
+func TestTermination(t *testing.T) {
+	testTermination(t, chandyLamport, "both")
+	testTermination(t, laiYang, "snapshot")
+}
+
+func testTermination[M collect.Message, P Process[M]](t *testing.T, a Algorithm[M, P], termination string) {
+	triangle := node.FullyConnected(1, 2, 3)
+	cfg := Config{Workload: app.JobsWorkload(2), Termination: termination, Every: 2, Probes: 20}
+	pred, err := predicate.Parse("quiet", app.IdleIn(cfg.Workload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Until = &pred
+	for seed := int64(0); seed < 10; seed++ {
+		cfg.Seed = seed
+		r := a.Simulate(triangle, app.JobsScenario(triangle, 4), cfg)
+		if r.Ended == 0 {
+			t.Fatalf("%s, seed %d: the computation never terminated", a.Name, seed)
+		}
+		if r.BySnapshot == 0 || r.BySnapshot < r.Ended {
+			t.Errorf("%s, seed %d: terminated at step %d, detected by snapshot at %d", a.Name, seed, r.Ended, r.BySnapshot)
+		}
+		if termination == "both" && (r.BySafra == 0 || r.BySafra < r.Ended) {
+			t.Errorf("%s, seed %d: terminated at step %d, detected by Safra's token at %d", a.Name, seed, r.Ended, r.BySafra)
+		}
+	}
+}
+
+// Real-time runs, one goroutine per process and more for the network, the
+// work and the detectors: go test -race checks they share nothing unguarded
+func TestRealTime(t *testing.T) {
+	testRealTime(t, chandyLamport, "both")
+	testRealTime(t, laiYang, "snapshot")
+}
+
+func testRealTime[M collect.Message, P Process[M]](t *testing.T, a Algorithm[M, P], termination string) {
+	demo := fixtureNamed(t, "concurrent")
+	crash := fixtureNamed(t, "crash")
+	triangle := demo.top
+	quiet := func(workload app.Workload) *predicate.Predicate {
+		pred, err := predicate.Parse("quiet", app.IdleIn(workload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pred
+	}
+	lossy := node.Faults{Drop: 0.2, Duplicate: 0.1, Delay: 5 * time.Millisecond}
+	bank := Config{Workload: app.BankWorkload(100), Money: app.BankTotal(triangle, 100)}
+	jobs := Config{Workload: app.JobsWorkload(2), Termination: termination, Every: 2, Probes: 20}
+	jobs.Until = quiet(jobs.Workload)
+	until := Config{Workload: app.LogWorkload, Every: 2, Probes: 5}
+	until.Until = quiet(until.Workload)
+
+	tests := []struct {
+		name       string
+		sc         sim.Scenario
+		cfg        Config
+		consistent bool // every snapshot must come out consistent
+	}{
+		{"demo", demo.sc, demo.cfg, true},
+		{"bank", app.BankScenario(triangle, 5, 25, 1), bank, true},
+		{"crash", crash.sc, crash.cfg, true},
+		{"faults", demo.sc, Config{Workload: app.LogWorkload, Faults: lossy}, false},
+		{"reliable", demo.sc, Config{Workload: app.LogWorkload, Faults: lossy, Reliable: true}, true},
+		{"until", demo.sc, until, true},
+		{"termination", app.JobsScenario(triangle, 4), jobs, true},
+	}
+	for _, tt := range tests {
+		t.Run(a.Name+"/"+tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Tick, cfg.Seed, cfg.FaultSeed = 5*time.Millisecond, -1, 1
+			r := a.Simulate(triangle, tt.sc, cfg)
+			if tt.consistent && (r.Total == 0 || r.Good < r.Total) {
+				t.Errorf("%d of %d snapshots consistent", r.Good, r.Total)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package harness
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"snapshot/app"
+	"snapshot/checkpoint"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/sim"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// Main is the program of the algorithm: it parses the command line and
+// runs the simulation, an exploration, a process over TCP or a check of
+// what such processes left behind
+func (a Algorithm[M, P]) Main() {
+	nodeID := flag.Int("node", 0, "run as process N over TCP instead of the in-memory simulation")
+	peers := flag.String("peers", "1=127.0.0.1:9001,2=127.0.0.1:9002,3=127.0.0.1:9003", "TCP peer list id=host:port,...")
+	topoFile := flag.String("topology", "", "JSON topology file (default: fully connected triangle)")
+	scenarioFile := flag.String("scenario", "", "scenario file driving the simulation, or with -node the actions of this process (default: built-in demo)")
+	tick := flag.Duration("tick", 50*time.Millisecond, "real time per scenario step")
+	seed := flag.Int64("seed", -1, "run deterministically, the seed picking which channel delivers next")
+	reorder := flag.Float64("reorder", 0, "with -seed, chance that a delivery overtakes older messages on its channel")
+	window := flag.Int("window", 3, "with -reorder, how many of the oldest messages on a channel may be delivered")
+	drop := flag.Float64("drop", 0, "chance that the network loses a message")
+	dup := flag.Float64("dup", 0, "chance that the network delivers a message twice")
+	delay := flag.Duration("delay", 0, "hold messages up to this long, letting later ones overtake them")
+	partition := flag.String("partition", "", "links cut off, as i-j,k-l,...")
+	heal := flag.Duration("heal", 0, "with -partition, reconnect the links after this long")
+	faultSeed := flag.Int64("fault-seed", 1, "seed for the network faults")
+	reliable := flag.Bool("reliable", false, "restore reliable FIFO channels with sequence numbers, acks and resends")
+	checkpoints := flag.String("checkpoints", "", "directory to save completed snapshots to, and recover crashed processes from")
+	exhaustive := flag.Bool("explore", false, "check the scenario under every delivery interleaving")
+	maxRuns := flag.Int("max-runs", 100000, "with -explore, give up after this many interleavings")
+	appName := flag.String("app", "log", "workload: log (messages appended to a string), bank (balance transfers) or jobs (a diffusing computation)")
+	balance := flag.Int("balance", 100, "with -app bank, opening balance of accounts without one in the topology")
+	rounds := flag.Int("rounds", 20, "with -app bank and no scenario, rounds of random transfers")
+	fanout := flag.Int("fanout", 2, "with -app jobs, jobs a process owes for every job it gets")
+	budget := flag.Int("budget", 4, "with -app jobs and no scenario, budget of the first job, each job handing out jobs of one less")
+	events := flag.String("events", "", "write every send, receive, control message and record to this file as JSON Lines")
+	causal := flag.String("causality", "", "query a trace written by -events: given two event times, how they are related by causality, otherwise whether each snapshot is a consistent cut by vector clocks")
+	until := flag.String("until", "", "after the scenario, keep taking snapshots until one finds this stable predicate holding: empty, idle, quiet or total=N, joined by commas")
+	every := flag.Int("every", 5, "with -until or -termination, scenario steps between snapshots")
+	probes := flag.Int("probes", 20, "with -until or -termination, snapshots to take before giving up")
+	termination := flag.String("termination", "", "detect the computation terminating by snapshot, safra (Safra's token, around the processes in ID order) or both, and compare the control messages each takes")
+	diagram := flag.String("diagram", "", "draw the space-time diagram of each snapshot to this file, numbered per snapshot: SVG for .svg, text otherwise, - for text on stdout")
+	linger := flag.Int("linger", 20, "with -node, steps to stay up after the scenario once nothing arrives, for peers still taking snapshots")
+	check := flag.String("check", "", "check a run of -node processes: the traces they wrote with -events, comma separated, against the snapshots they saved to -checkpoints, as simulated runs are checked")
+	flag.Parse()
+
+	exit := func(err error) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *causal != "" {
+		if err := causality(os.Stdout, *causal, flag.Args()); err != nil {
+			exit(err)
+		}
+		return
+	}
+	var top node.Topology
+	if *topoFile != "" {
+		var err error
+		if top, err = node.LoadTopology(*topoFile); err != nil {
+			exit(err)
+		}
+	}
+
+	var sink *trace.JSONLWriter
+	if *events != "" {
+		f, err := os.Create(*events)
+		if err != nil {
+			exit(err)
+		}
+		defer f.Close()
+		sink = trace.NewJSONLWriter(f)
+	}
+	flush := func() {
+		if sink != nil {
+			if err := sink.Flush(); err != nil {
+				exit(err)
+			}
+		}
+	}
+
+	// The triangle demo unless told otherwise, nodes fully connecting their peers
+	var peerAddrs map[int]string
+	if *nodeID != 0 || *check != "" {
+		var err error
+		if peerAddrs, err = node.ParsePeers(*peers); err != nil {
+			exit(err)
+		}
+	}
+	if top.Nodes == nil {
+		if peerAddrs != nil {
+			top = node.FullyConnected(node.PeerIDs(peerAddrs)...)
+		} else {
+			top = node.FullyConnected(1, 2, 3)
+		}
+	}
+	var sc sim.Scenario
+	var err error
+	switch {
+	case *scenarioFile != "":
+		sc, err = sim.LoadScenario(*scenarioFile)
+	case *appName == "bank":
+		scenarioSeed := *seed
+		if scenarioSeed < 0 {
+			scenarioSeed = 1
+		}
+		sc = app.BankScenario(top, *rounds, (*balance+3)/4, scenarioSeed)
+	case *appName == "jobs":
+		sc = app.JobsScenario(top, *budget)
+	case *topoFile == "":
+		sc, err = sim.ParseScenario(strings.NewReader(a.Demo))
+	default:
+		sc = sim.GenerateScenario(top)
+	}
+	if err == nil {
+		err = sc.Validate(top)
+	}
+	if err == nil && *reorder > 0 && *seed < 0 {
+		err = fmt.Errorf("-reorder needs a deterministic run, give a -seed")
+	}
+	cfg := Config{Tick: *tick, Seed: *seed, Reorder: *reorder, Window: *window, FaultSeed: *faultSeed, Reliable: *reliable}
+	cfg.Faults = node.Faults{Drop: *drop, Duplicate: *dup, Delay: *delay, Heal: *heal}
+	if err == nil && *partition != "" {
+		cfg.Faults.Partition, err = node.ParseLinks(*partition)
+	}
+	if err == nil && (cfg.Faults.Any() || cfg.Reliable) && *seed >= 0 {
+		err = fmt.Errorf("network faults and -reliable need a real-time run, drop -seed")
+	}
+	cfg.Checkpoints = *checkpoints
+	switch *appName {
+	case "log":
+		cfg.Workload = app.LogWorkload
+	case "bank":
+		cfg.Workload = app.BankWorkload(*balance)
+		cfg.Money = app.BankTotal(top, *balance)
+	case "jobs":
+		cfg.Workload = app.JobsWorkload(*fanout)
+	default:
+		err = fmt.Errorf("unknown -app %q", *appName)
+	}
+	if err == nil && *until != "" {
+		var pred predicate.Predicate
+		pred, err = predicate.Parse(*until, app.IdleIn(cfg.Workload))
+		cfg.Until, cfg.Every, cfg.Probes = &pred, *every, *probes
+		if err == nil && (*every < 1 || *probes < 1) {
+			err = fmt.Errorf("-every and -probes must be at least 1")
+		}
+	}
+	if err == nil && *termination != "" {
+		switch {
+		case *termination != "snapshot" && *termination != "safra" && *termination != "both":
+			err = fmt.Errorf("unknown -termination %q, want snapshot, safra or both", *termination)
+		case cfg.Until != nil:
+			err = fmt.Errorf("-termination detects quiet by snapshot itself, drop -until")
+		case *every < 1 || *probes < 1:
+			err = fmt.Errorf("-every and -probes must be at least 1")
+		case *termination != "safra":
+			// terminated is every process passive and every channel state empty
+			var pred predicate.Predicate
+			pred, err = predicate.Parse("quiet", app.IdleIn(cfg.Workload))
+			cfg.Until = &pred
+		}
+		// also paces real-time runs waiting for Safra's token
+		cfg.Termination, cfg.Every, cfg.Probes = *termination, *every, *probes
+	}
+	if err == nil && sc.HasCrashes() {
+		switch {
+		case *exhaustive:
+			err = fmt.Errorf("crash scenarios cannot be explored")
+		case cfg.Checkpoints == "":
+			err = fmt.Errorf("crash scenarios need -checkpoints to recover from")
+		case cfg.Faults.Any() || cfg.Reliable:
+			err = fmt.Errorf("crash scenarios run over plain channels, drop the network faults and -reliable")
+		case cfg.Until != nil:
+			err = fmt.Errorf("crash scenarios cannot be combined with -until or -termination")
+		}
+	}
+	if err == nil && (*nodeID != 0 || *check != "") {
+		switch {
+		case *exhaustive || *seed >= 0 || *diagram != "" || cfg.Until != nil || cfg.Termination != "":
+			err = fmt.Errorf("-explore, -seed, -diagram, -until and -termination work on simulated runs, not nodes")
+		case cfg.Faults.Any() || cfg.Reliable:
+			err = fmt.Errorf("network faults and -reliable work on simulated runs, not nodes")
+		case *appName == "jobs":
+			err = fmt.Errorf("-app jobs works on simulated runs, not nodes")
+		case sc.HasCrashes():
+			err = fmt.Errorf("crash scenarios work on simulated runs, not nodes")
+		case *check != "" && cfg.Checkpoints == "":
+			err = fmt.Errorf("-check needs the -checkpoints directory the nodes saved their snapshots to")
+		}
+	}
+	if err == nil && *exhaustive && (*diagram != "" || cfg.Until != nil || cfg.Termination != "") {
+		err = fmt.Errorf("-diagram, -until and -termination work on a single run, not an exploration")
+	}
+	if err != nil {
+		exit(err)
+	}
+	cfg.Log, cfg.Out = log.New(os.Stdout, "", 0), os.Stdout
+	if *check != "" {
+		if err := a.CheckNodes(strings.Split(*check, ","), top, cfg); err != nil {
+			exit(err)
+		}
+		return
+	}
+	if *nodeID != 0 {
+		var t *trace.Trace
+		if sink != nil {
+			t = &trace.Trace{Sink: sink}
+		}
+		if err := a.runNode(*nodeID, peerAddrs, top, sc, cfg, *linger, t); err != nil {
+			exit(err)
+		}
+		flush()
+		return
+	}
+	if *exhaustive {
+		a.explore(top, sc, cfg, *maxRuns)
+		return
+	}
+	if sink != nil {
+		cfg.Sink = sink
+	}
+	cfg.Diagram = *diagram
+	a.Simulate(top, sc, cfg)
+	flush()
+}
+
+// Run one process over TCP, start one per peer. The process plays its own
+// actions of the scenario, a step per tick, then stays up for its peers
+// until nothing has arrived for linger steps. Without a topology the peers
+// are fully connected. Each node can save its trace and snapshots:
+//
+//	go run . -node 1 -events 1.jsonl -checkpoints ck & go run . -node 2 -events 2.jsonl -checkpoints ck & go run . -node 3 -events 3.jsonl -checkpoints ck
+//
+// for -check 1.jsonl,2.jsonl,3.jsonl -checkpoints ck to check afterwards.
+func (a Algorithm[M, P]) runNode(id int, peers map[int]string, top node.Topology, sc sim.Scenario, cfg Config, linger int, t *trace.Trace) error {
+	tr, err := node.ListenTCP(id, peers, 64)
+	if err != nil {
+		return err
+	}
+	tr.Log = cfg.Log
+	defer tr.Close()
+
+	nodes := top.Build(tr)
+	n := nodes[id]
+	if n == nil {
+		return fmt.Errorf("P%d is not in the topology", id)
+	}
+	n.Log = cfg.Log
+	if cfg.Checkpoints != "" {
+		if err := os.MkdirAll(cfg.Checkpoints, 0o755); err != nil {
+			return err
+		}
+	}
+	collector := collect.NewCollector[M](n)
+	p := a.New(n, cfg.Workload(id, top.InitialState(id)), t, report(n, cfg.Checkpoints, collector))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	silent := 0 // steps since something arrived, kept on the event loop
+	go n.Run(ctx, func(from int, v interface{}) {
+		silent = 0
+		p.Receive(from, v)
+	})
+
+	sc.Play(cfg.Tick, func(act sim.Action) {
+		if act.From != id {
+			return
+		}
+		switch act.Kind {
+		case sim.SendAction:
+			n.Do(func() { p.SendMessage(act.To, act.Data) })
+		case sim.SnapshotAction:
+			fmt.Fprintf(cfg.Out, "\n--- P%d initiates %s snapshot ---\n", id, a.Name)
+			n.Do(func() { p.Initiate() })
+		}
+	})
+	tick := time.NewTicker(cfg.Tick)
+	defer tick.Stop()
+	for done := false; !done; {
+		<-tick.C
+		n.Do(func() {
+			silent++
+			done = silent >= linger
+		})
+	}
+
+	last := 0
+	n.Do(func() { last = p.LastSnapshot() })
+	for k := 1; k <= last; k++ {
+		g := collector.Collect(k, 0)
+		fmt.Fprintf(cfg.Out, "\n--- %s snapshot #%d result ---\n", a.Name, k)
+		if s, ok := g.Processes[id]; ok {
+			fmt.Fprintln(cfg.Out, describe(s))
+		} else {
+			fmt.Fprintf(cfg.Out, "P%d did not complete its snapshot\n", id)
+		}
+	}
+	return nil
+}
+
+// Run the scenario under every delivery interleaving and print whether
+// each snapshot taken is a consistent cut
+func (a Algorithm[M, P]) explore(top node.Topology, sc sim.Scenario, cfg Config, maxRuns int) {
+	runs, pruned, err := a.Explore(top, sc, cfg, maxRuns)
+	fmt.Fprintf(cfg.Out, "explored %d interleavings (%d pruned as equivalent)\n", runs, pruned)
+	if f, ok := err.(*sim.Failure); ok {
+		fmt.Fprintf(cfg.Out, "inconsistent cut under %v\n", f)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(cfg.Out, "%v, every snapshot so far is a consistent cut\n", err)
+		return
+	}
+	fmt.Fprintln(cfg.Out, "every snapshot is a consistent cut")
+	if cfg.Money > 0 {
+		fmt.Fprintf(cfg.Out, "and holds the %d the bank started with\n", cfg.Money)
+	}
+}
+
+// Answer causality queries on a trace written by -events: how the events at
+// two times are related or, given none, whether the points where processes
+// recorded each snapshot form a consistent cut by their vector clocks
+func causality(out io.Writer, path string, times []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	events, err := trace.ReadEvents(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch len(times) {
+	case 0:
+	case 2:
+		byTime := map[int]trace.Event{}
+		for _, e := range events {
+			byTime[e.Time] = e
+		}
+		var es []trace.Event
+		for _, a := range times {
+			t, err := strconv.Atoi(a)
+			if err != nil {
+				return fmt.Errorf("bad event time %q", a)
+			}
+			e, ok := byTime[t]
+			if !ok {
+				return fmt.Errorf("no event at time %d in %s", t, path)
+			}
+			es = append(es, e)
+		}
+		fmt.Fprintf(out, "%s\n  %v\n%s\n", trace.Describe(es[0]), es[0].Vector.Compare(es[1].Vector), trace.Describe(es[1]))
+		return nil
+	default:
+		return fmt.Errorf("want the times of two events to relate, or none")
+	}
+
+	cuts := map[int]map[int]vclock.VectorClock{} // snapshot -> process -> recording point
+	var ids []int
+	for _, e := range events {
+		if e.Kind != trace.RecordEvent {
+			continue
+		}
+		if cuts[e.Snapshot] == nil {
+			cuts[e.Snapshot] = map[int]vclock.VectorClock{}
+			ids = append(ids, e.Snapshot)
+		}
+		cuts[e.Snapshot][e.Process] = e.Vector
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if err := vclock.ConsistentCut(cuts[id]); err != nil {
+			fmt.Fprintf(out, "snapshot #%d is not a consistent cut:\n%v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(out, "snapshot #%d is a consistent cut of %d processes\n", id, len(cuts[id]))
+	}
+	return nil
+}
+
+// CheckNodes checks a run of -node processes as simulated runs are checked:
+// the traces they wrote, merged into one run, against the snapshots they
+// checkpointed to cfg.Checkpoints
+func (a Algorithm[M, P]) CheckNodes(paths []string, top node.Topology, cfg Config) error {
+	if cfg.Out == nil {
+		cfg.Out = io.Discard
+	}
+	var traces [][]trace.Event
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		es, err := trace.ReadEvents(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		traces = append(traces, es)
+	}
+	events := trace.Merge(traces...)
+	if err := trace.VerifyClocks(events); err != nil {
+		return err
+	}
+
+	locals, err := checkpoint.ReadAll[M](cfg.Checkpoints)
+	if err != nil {
+		return err
+	}
+	collector := collect.NewCollector[M](build(top, nil, nil)...)
+	seen := map[int]bool{}
+	var ids []int
+	for _, s := range locals {
+		collector.Add(s)
+		if !seen[s.Snapshot] {
+			seen[s.Snapshot] = true
+			ids = append(ids, s.Snapshot)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no snapshots in %s", cfg.Checkpoints)
+	}
+	sort.Ints(ids)
+	good := 0
+	for _, id := range ids {
+		if printSnapshot(cfg.Out, a.Name, collector.Collect(id, 0), events, cfg.Money) {
+			good++
+		}
+	}
+	if good < len(ids) {
+		return fmt.Errorf("\n%d of %d snapshots are not consistent cuts of the run", len(ids)-good, len(ids))
+	}
+	fmt.Fprintf(cfg.Out, "\nall %d snapshots are consistent cuts of the run\n", len(ids))
+	return nil
+}
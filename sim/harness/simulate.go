@@ -0,0 +1,292 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"snapshot/app"
+	"snapshot/checkpoint"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/sim"
+	"snapshot/spacetime"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// Simulate the topology in one binary, driven by the scenario. With a seed
+// of 0 or more the run is deterministic: no goroutines, one scheduler
+// picking each delivery, so the same seed always gives the same run.
+// Reordering then makes the channels non-FIFO. Real-time runs can go over
+// a faulty network instead, optionally made reliable again. Given a
+// predicate, the first process keeps taking snapshots after the scenario's
+// last action until one finds it holding. Processes whose application is a
+// Worker get a unit of work every step, and detecting their termination
+// with Safra's token starts after the last action too.
+func (a Algorithm[M, P]) Simulate(top node.Topology, sc sim.Scenario, cfg Config) Result {
+	if cfg.Out == nil {
+		cfg.Out = io.Discard
+	}
+	var net node.Transport
+	var sched *sim.Scheduler
+	if cfg.Seed >= 0 {
+		sched = sim.NewScheduler(top, cfg.Seed)
+		sched.Reorder, sched.Window = cfg.Reorder, cfg.Window
+		net = sched
+	} else {
+		if cfg.Reliable {
+			// acks need a way back on every channel
+			net = node.NewChanTransport(top.Bidirectional(), 10)
+		} else {
+			net = node.NewChanTransport(top, 10)
+		}
+		if cfg.Faults.Any() {
+			fmt.Fprintf(cfg.Out, "faulty network: drop %.2f, dup %.2f, delay %v, partition %v healing after %v\n",
+				cfg.Faults.Drop, cfg.Faults.Duplicate, cfg.Faults.Delay, cfg.Faults.Partition, cfg.Faults.Heal)
+			faulty := node.NewFaultyTransport(net, cfg.Faults, cfg.FaultSeed)
+			faulty.Log = cfg.Log
+			defer faulty.Close()
+			net = faulty
+		}
+		if cfg.Reliable {
+			r := node.NewReliableTransport(net, top.Nodes, 20*time.Millisecond)
+			r.Log = cfg.Log
+			defer r.Close()
+			net = r
+		}
+	}
+	var r Result
+	if cfg.Checkpoints != "" {
+		if err := checkpoint.Clear(cfg.Checkpoints); err != nil {
+			fmt.Fprintln(cfg.Out, err)
+			return r
+		}
+	}
+	sys := a.newSystem(top, net, cfg)
+	// The detectors start once the scenario has no more input to give, so
+	// that stable predicates stay put
+	var d *predicate.Detector
+	last := 0
+	if n := len(sc.Actions); n > 0 {
+		last = sc.Actions[n-1].Step
+	}
+	if cfg.Until != nil {
+		d = sys.detector(*cfg.Until, cfg.Probes)
+	}
+	safra := cfg.Termination == "safra" || cfg.Termination == "both"
+	if safra {
+		if err := sys.ring(); err != nil {
+			fmt.Fprintln(cfg.Out, err)
+			return r
+		}
+	}
+
+	timeout := 1*time.Second + cfg.Faults.Heal
+	if sched != nil {
+		fmt.Fprintf(cfg.Out, "deterministic run, seed %d\n", cfg.Seed)
+		if cfg.Reorder > 0 {
+			fmt.Fprintf(cfg.Out, "non-FIFO channels: reorder %.2f, window %d\n", cfg.Reorder, cfg.Window)
+		}
+		sys.attach(sched)
+		// note the first step by which termination happened and each
+		// detector saw it
+		now := 0
+		note := func(step int) {
+			now = step
+			if cfg.Termination == "" {
+				return
+			}
+			if r.Ended == 0 && sys.terminated() {
+				r.Ended = step
+			}
+			if r.BySnapshot == 0 && d != nil && d.Found != 0 {
+				r.BySnapshot = step
+			}
+			if r.BySafra == 0 && safra && sys.detected() {
+				r.BySafra = step
+			}
+		}
+		sched.OnStep = func(step int) {
+			note(step)
+			sys.work()
+			if safra && step == last {
+				sys.detectTermination()
+			}
+			if d != nil && step >= last && (step-last)%cfg.Every == 0 {
+				d.Probe()
+			}
+		}
+		// keep going while there is work, probing or a token left
+		sched.Busy = func() bool {
+			return sys.active() || d != nil && !d.Over() || !sys.detected()
+		}
+		sched.Run(sc, sys.do)
+		note(now + 1)
+		// every report is made before the run returns
+		timeout = 0
+	} else {
+		// Start handlers, stopped once the snapshots are collected
+		sys.loop = true
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		for _, p := range sys.procs {
+			wg.Add(1)
+			go func(p *proc[M, P]) {
+				defer wg.Done()
+				p.Run(ctx, p.alg.Receive)
+			}(p)
+		}
+		defer wg.Wait()
+		defer cancel()
+		// units of work every tick, stopped before the handlers
+		if _, ok := sys.procs[0].alg.App().(app.Worker); ok {
+			working, stop := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(working)
+				t := time.NewTicker(cfg.Tick)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+						sys.work()
+					case <-stop:
+						return
+					}
+				}
+			}()
+			defer func() {
+				close(stop)
+				<-working
+			}()
+		}
+		sc.Play(cfg.Tick, sys.do)
+		if safra {
+			sys.detectTermination()
+		}
+		if d != nil || safra {
+			t := time.NewTicker(time.Duration(cfg.Every) * cfg.Tick)
+			defer t.Stop()
+			// a snapshot or token lost to the network would otherwise be waited for forever
+			giveUp := time.After(time.Duration(cfg.Probes+1)*time.Duration(cfg.Every)*cfg.Tick + time.Second)
+		probing:
+			for !(d == nil || d.Probe()) || !sys.detected() {
+				select {
+				case <-t.C:
+				case <-giveUp:
+					break probing
+				}
+			}
+		}
+	}
+	switch {
+	case d == nil || d.Found != 0:
+	case d.Pending != 0:
+		fmt.Fprintf(cfg.Out, "\n%s not detected, snapshot #%d did not complete\n", d.Pred.Name, d.Pending)
+	default:
+		fmt.Fprintf(cfg.Out, "\n%s still did not hold after %d snapshots\n", d.Pred.Name, d.Taken)
+	}
+
+	// Wait for every process to complete its part of each snapshot, then print them
+	ts := sys.snapshots(timeout)
+	for _, t := range ts {
+		if printSnapshot(cfg.Out, a.Name, t.g, t.events, cfg.Money) {
+			r.Good++
+		}
+		if cfg.Diagram != "" {
+			d := spacetime.FromTrace(t.events, t.g.ID, t.g.Recorded(), a.Diagram)
+			d.Title = title(a.Name, t.g)
+			if err := spacetime.WriteDiagram(cfg.Diagram, t.g.ID, d); err != nil {
+				fmt.Fprintln(cfg.Out, err)
+			}
+		}
+	}
+	r.Total = len(ts)
+	if cfg.Money > 0 {
+		fmt.Fprintf(cfg.Out, "\n%d of %d snapshots are consistent and hold the %d the bank started with\n", r.Good, r.Total, cfg.Money)
+	}
+	if cfg.Termination != "" {
+		sys.reportTermination(d, r)
+	}
+	return r
+}
+
+// Explore runs the scenario under every delivery interleaving and checks
+// each snapshot taken is a consistent cut, see sim.Explorer. The runs say
+// nothing as they go.
+func (a Algorithm[M, P]) Explore(top node.Topology, sc sim.Scenario, cfg Config, maxRuns int) (runs, pruned int, err error) {
+	return a.explorer(top, sc, cfg, maxRuns).Explore()
+}
+
+// Set up the exploration of the scenario, every run built and checked as
+// deterministic runs are
+func (a Algorithm[M, P]) explorer(top node.Topology, sc sim.Scenario, cfg Config, maxRuns int) *sim.Explorer {
+	cfg.Checkpoints, cfg.Sink, cfg.Log, cfg.Out = "", nil, nil, nil
+	return &sim.Explorer{
+		Top:      top,
+		Scenario: sc,
+		MaxRuns:  maxRuns,
+		Setup: func(sched *sim.Scheduler) (func(sim.Action), func() error) {
+			sys := a.newSystem(top, sched, cfg)
+			sys.attach(sched)
+			return sys.do, sys.check
+		},
+	}
+}
+
+// Title of a snapshot in diagrams and results
+func title[M collect.Message](name string, g collect.Global[M]) string {
+	s := fmt.Sprintf("%s snapshot #%d", name, g.ID)
+	if len(g.Initiators) > 0 {
+		s += fmt.Sprintf(" (initiators %v)", g.Initiators)
+	}
+	return s
+}
+
+// Describe a local snapshot
+func describe[M collect.Message](s collect.Local[M]) string {
+	region := ""
+	if s.Initiator != 0 {
+		region = fmt.Sprintf(" (region of P%d)", s.Initiator)
+	}
+	return fmt.Sprintf("P%d%s state at clock %d: '%s', channel states: %v", s.Process, region, s.Clock, s.State, s.Channels)
+}
+
+// Print a global snapshot and whether it is a consistent cut of the run and,
+// with money to account for, holds all of it. Reports whether all is well.
+func printSnapshot[M collect.Message](out io.Writer, name string, g collect.Global[M], events []trace.Event, money int) bool {
+	fmt.Fprintf(out, "\n--- %s results ---\n", title(name, g))
+	var pids []int
+	for pid := range g.Processes {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		fmt.Fprintln(out, describe(g.Processes[pid]))
+	}
+	if !g.Complete() {
+		fmt.Fprintf(out, "incomplete: missing processes %v, missing channels %v\n", g.Missing, g.MissingChannels)
+		return false
+	}
+	if err := g.Verify(events); err != nil {
+		fmt.Fprintf(out, "inconsistent cut:\n%v\n", err)
+		return false
+	}
+	if err := vclock.ConsistentCut(g.Vectors()); err != nil {
+		fmt.Fprintf(out, "inconsistent cut by vector clocks:\n%v\n", err)
+		return false
+	}
+	fmt.Fprintln(out, "cut is consistent")
+	if money > 0 {
+		if err := verifyMoney(g, money); err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		fmt.Fprintf(out, "money is conserved: %d\n", money)
+	}
+	return true
+}
@@ -0,0 +1,449 @@
+package harness
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"snapshot/app"
+	"snapshot/checkpoint"
+	"snapshot/collect"
+	"snapshot/node"
+	"snapshot/predicate"
+	"snapshot/sim"
+	"snapshot/trace"
+	"snapshot/vclock"
+)
+
+// proc is a process of the algorithm together with the node it runs on
+type proc[M collect.Message, P Process[M]] struct {
+	*node.Node
+	alg  P
+	turn int // outgoing channel the next unit of work goes out on
+}
+
+// Do a unit of the application's own work, if it has any, sending what it
+// produces on the outgoing channels in turn
+func (p *proc[M, P]) work() {
+	if p.Down {
+		return
+	}
+	if w, ok := p.alg.App().(app.Worker); ok && len(p.Out) > 0 {
+		if data, ok := w.Work(); ok {
+			p.alg.SendMessage(p.Out[p.turn%len(p.Out)], data)
+			p.turn++
+		}
+	}
+}
+
+// Passive processes have no work of their own left
+func (p *proc[M, P]) idle() bool {
+	a := p.alg.App()
+	i, ok := a.(app.Idler)
+	return !ok || i.Idle(a.State())
+}
+
+// The process's part in Safra's detection, set up by system.ring
+func (p *proc[M, P]) ring() TokenRing {
+	return any(p.alg).(TokenRing)
+}
+
+// system is one process per node of a topology on a shared transport,
+// with the collector and trace needed to check their snapshots
+type system[M collect.Message, P Process[M]] struct {
+	alg         Algorithm[M, P]
+	procs       []*proc[M, P] // in ID order
+	byID        map[int]*proc[M, P]
+	net         node.Transport
+	collector   *collect.Collector[M]
+	trace       *trace.Trace
+	ids         []int // snapshot IDs initiated
+	seen        map[int]bool
+	earlier     []taken[M] // snapshots from before the last rollback
+	loop        bool       // processes run their own event loops
+	safra       bool       // processes are on a ring for Safra's detection
+	checkpoints string     // directory the processes checkpoint to, if any
+	money       int        // total of the bank workload, 0 for other workloads
+	log         node.Logger
+	out         io.Writer
+}
+
+// taken is a snapshot with the events it is checked against
+type taken[M collect.Message] struct {
+	g      collect.Global[M]
+	events []trace.Event
+}
+
+// Build one process per node of the topology on net, running the
+// workload of cfg and saving completed snapshots to its checkpoints
+func (a Algorithm[M, P]) newSystem(top node.Topology, net node.Transport, cfg Config) *system[M, P] {
+	s := &system[M, P]{
+		alg:         a,
+		byID:        map[int]*proc[M, P]{},
+		net:         net,
+		trace:       &trace.Trace{Sink: cfg.Sink},
+		seen:        map[int]bool{},
+		checkpoints: cfg.Checkpoints,
+		money:       cfg.Money,
+		log:         cfg.Log,
+		out:         cfg.Out,
+	}
+	if s.out == nil {
+		s.out = io.Discard
+	}
+	nodes := build(top, net, cfg.Log)
+	s.collector = collect.NewCollector[M](nodes...)
+	for _, n := range nodes {
+		p := &proc[M, P]{Node: n}
+		p.alg = a.New(n, cfg.Workload(n.ID, top.InitialState(n.ID)), s.trace, report(n, cfg.Checkpoints, s.collector))
+		s.procs = append(s.procs, p)
+		s.byID[n.ID] = p
+	}
+	return s
+}
+
+// Build the nodes of the topology on net, in ID order
+func build(top node.Topology, net node.Transport, log node.Logger) []*node.Node {
+	var nodes []*node.Node
+	for _, n := range top.Build(net) {
+		n.Log = log
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Hand the completed local snapshots of the process on n to the collector,
+// saving each to dir first if given
+func report[M collect.Message](n *node.Node, dir string, c *collect.Collector[M]) func(collect.Local[M]) {
+	return func(l collect.Local[M]) {
+		if dir != "" {
+			if err := checkpoint.Save(dir, l); err != nil {
+				n.Logf("P%d: %v\n", n.ID, err)
+			}
+		}
+		c.Add(l)
+	}
+}
+
+// Say what the run does alongside the processes
+func (s *system[M, P]) logf(format string, args ...interface{}) {
+	if s.log != nil {
+		s.log.Printf(format, args...)
+	}
+}
+
+// Attach every process to the scheduler, a crashed one losing what it is sent
+func (s *system[M, P]) attach(sched *sim.Scheduler) {
+	for _, p := range s.procs {
+		sched.Attach(p.ID, func(from int, v interface{}) {
+			if !p.Down {
+				p.alg.Receive(from, v)
+			}
+		})
+	}
+}
+
+// Perform one scenario action
+func (s *system[M, P]) do(a sim.Action) {
+	p := s.byID[a.From]
+	switch a.Kind {
+	case sim.SendAction:
+		s.on(p, func() { p.alg.SendMessage(a.To, a.Data) })
+	case sim.SnapshotAction:
+		s.initiate(p)
+	case sim.CrashAction:
+		s.logf("\n--- P%d crashes ---\n", p.ID)
+		s.on(p, func() { p.Down = true })
+	case sim.RecoverAction:
+		s.logf("\n--- P%d recovers ---\n", p.ID)
+		if err := s.rollback(); err != nil {
+			fmt.Fprintf(s.out, "P%d cannot recover: %v\n", p.ID, err)
+		}
+	}
+}
+
+// Start a snapshot from p, returns its ID or 0 if p is down
+func (s *system[M, P]) initiate(p *proc[M, P]) int {
+	s.logf("\n--- P%d initiates %s snapshot ---\n", p.ID, s.alg.Name)
+	var id int
+	s.on(p, func() { id = p.alg.Initiate() })
+	// Concurrent initiations may share an ID, so each ID is collected once
+	if id != 0 && !s.seen[id] {
+		s.seen[id] = true
+		s.ids = append(s.ids, id)
+	}
+	return id
+}
+
+// Run f on p's event loop when it has one, unless p has crashed
+func (s *system[M, P]) on(p *proc[M, P], f func()) {
+	s.at(p, func() {
+		if p.Down {
+			s.logf("P%d is down\n", p.ID)
+			return
+		}
+		f()
+	})
+}
+
+// Run f on p's event loop when it has one, down or not
+func (s *system[M, P]) at(p *proc[M, P], f func()) {
+	if s.loop {
+		p.Do(f)
+	} else {
+		f()
+	}
+}
+
+// Give every process a unit of its own work
+func (s *system[M, P]) work() {
+	for _, p := range s.procs {
+		s.at(p, p.work)
+	}
+}
+
+// Report whether a process that is up still has work of its own
+func (s *system[M, P]) active() bool {
+	busy := false
+	for _, p := range s.procs {
+		s.at(p, func() { busy = busy || !p.Down && !p.idle() })
+	}
+	return busy
+}
+
+// Report whether the computation has terminated: every process passive and
+// every application message sent received
+func (s *system[M, P]) terminated() bool {
+	sent := 0
+	for _, e := range s.trace.Events() {
+		switch e.Kind {
+		case trace.SendEvent:
+			sent++
+		case trace.ReceiveEvent:
+			sent--
+		}
+	}
+	return sent == 0 && !s.active()
+}
+
+// Set up Safra's termination detection on a ring of the processes in ID
+// order, the first one initiating it once started. Counting starts here,
+// so it must come before the first message.
+func (s *system[M, P]) ring() error {
+	for i, p := range s.procs {
+		if _, ok := any(p.alg).(TokenRing); !ok {
+			return fmt.Errorf("%s has no Safra's token to detect termination with", s.alg.Name)
+		}
+		next := s.procs[(i+1)%len(s.procs)].ID
+		found := false
+		for _, to := range p.Out {
+			found = found || to == next
+		}
+		if !found || next == p.ID {
+			return fmt.Errorf("Safra's token needs a channel P%d->P%d", p.ID, next)
+		}
+		p.ring().JoinRing(next, i == 0)
+	}
+	s.safra = true
+	return nil
+}
+
+// Start Safra's detection at the first process
+func (s *system[M, P]) detectTermination() {
+	first := s.procs[0]
+	s.on(first, first.ring().DetectTermination)
+}
+
+// Report whether Safra's detection, if set up, has detected termination
+func (s *system[M, P]) detected() bool {
+	if !s.safra {
+		return true
+	}
+	p := s.procs[0]
+	done := false
+	s.at(p, func() { done, _, _ = p.ring().Detected() })
+	return done
+}
+
+// Print when the computation terminated and what detecting it took each
+// way, the steps known only to deterministic runs: control messages of
+// every snapshot, tokens of Safra's rounds
+func (s *system[M, P]) reportTermination(d *predicate.Detector, r Result) {
+	sent, controls := 0, 0
+	for _, e := range s.trace.Events() {
+		switch e.Kind {
+		case trace.SendEvent:
+			sent++
+		case s.alg.Diagram.ControlSend:
+			controls++
+		}
+	}
+	at := func(step int) string {
+		if step == 0 {
+			return ""
+		}
+		return fmt.Sprintf(" at step %d", step)
+	}
+	fmt.Fprintln(s.out, "\n--- Termination ---")
+	if r.Ended != 0 || s.terminated() {
+		fmt.Fprintf(s.out, "the computation sent %d messages and terminated%s\n", sent, at(r.Ended))
+	} else {
+		fmt.Fprintf(s.out, "the computation sent %d messages and has not terminated\n", sent)
+	}
+	if d != nil {
+		if d.Found != 0 {
+			fmt.Fprintf(s.out, "snapshots detected it%s: %d snapshots, %d %s\n", at(r.BySnapshot), d.Taken, controls, s.alg.Controls)
+		} else {
+			fmt.Fprintf(s.out, "snapshots did not detect it: %d snapshots, %d %s\n", d.Taken, controls, s.alg.Controls)
+		}
+	}
+	if s.safra {
+		rounds, tokens := 0, 0
+		for i, p := range s.procs {
+			s.at(p, func() {
+				_, r, t := p.ring().Detected()
+				if i == 0 {
+					rounds = r
+				}
+				tokens += t
+			})
+		}
+		if s.detected() {
+			fmt.Fprintf(s.out, "Safra's token detected it%s: %d rounds, %d tokens\n", at(r.BySafra), rounds, tokens)
+		} else {
+			fmt.Fprintf(s.out, "Safra's token did not detect it: %d rounds, %d tokens\n", rounds, tokens)
+		}
+	}
+}
+
+// Roll every process back to the latest snapshot they all checkpointed and
+// put the messages it recorded in transit back on their channels. Snapshots
+// still in progress are abandoned, and new ones continue after the highest
+// ID any process has seen.
+func (s *system[M, P]) rollback() error {
+	var ids []int
+	for _, p := range s.procs {
+		ids = append(ids, p.ID)
+	}
+	locals, err := checkpoint.Latest[M](s.checkpoints, ids)
+	if err != nil {
+		return err
+	}
+	net, ok := s.net.(interface{ Reset() })
+	if !ok {
+		return fmt.Errorf("cannot empty the channels of %T", s.net)
+	}
+	if s.loop {
+		for _, p := range s.procs {
+			resume := p.Pause()
+			defer resume()
+		}
+	}
+
+	// Snapshots taken so far are checked against the run up to here
+	s.earlier = s.snapshots(0)
+	s.ids, s.seen = nil, map[int]bool{}
+	s.trace.Clear()
+	net.Reset()
+
+	last := 0
+	for _, p := range s.procs {
+		if l := p.alg.LastSnapshot(); l > last {
+			last = l
+		}
+	}
+	for _, p := range s.procs {
+		p.alg.Restore(locals[p.ID], last)
+	}
+	// Put the recorded messages back in the order they were sent, so every
+	// sender's clocks keep rising along the trace
+	type replay struct {
+		to int
+		m  M
+	}
+	var replays []replay
+	for _, p := range s.procs {
+		for _, src := range p.In {
+			for _, m := range locals[p.ID].Channels[src] {
+				replays = append(replays, replay{p.ID, m})
+			}
+		}
+	}
+	sort.Slice(replays, func(i, j int) bool {
+		a, b := replays[i].m.ID(), replays[j].m.ID()
+		return a.From < b.From || a.From == b.From && a.Seq < b.Seq
+	})
+	for _, r := range replays {
+		if err := s.byID[r.m.ID().From].alg.Resend(r.to, r.m); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(s.out, "rolled back to snapshot #%d, replaying %d in-transit messages\n", locals[ids[0]].Snapshot, len(replays))
+	return nil
+}
+
+// Snapshots initiated so far, each collected within the timeout
+func (s *system[M, P]) snapshots(timeout time.Duration) []taken[M] {
+	sort.Ints(s.ids)
+	ts := append([]taken[M]{}, s.earlier...)
+	for _, id := range s.ids {
+		ts = append(ts, taken[M]{s.collector.Collect(id, timeout), s.trace.Events()})
+	}
+	return ts
+}
+
+// Check that every snapshot initiated completed as a consistent cut
+func (s *system[M, P]) check() error {
+	if err := trace.VerifyClocks(s.trace.Events()); err != nil {
+		return err
+	}
+	// under the scheduler every report is sent before the run returns
+	for _, t := range s.snapshots(0) {
+		if !t.g.Complete() {
+			return fmt.Errorf("snapshot #%d incomplete: missing processes %v, missing channels %v", t.g.ID, t.g.Missing, t.g.MissingChannels)
+		}
+		if err := t.g.Verify(t.events); err != nil {
+			return fmt.Errorf("snapshot #%d: %v", t.g.ID, err)
+		}
+		if err := vclock.ConsistentCut(t.g.Vectors()); err != nil {
+			return fmt.Errorf("snapshot #%d by vector clocks: %v", t.g.ID, err)
+		}
+		if s.money > 0 {
+			if err := verifyMoney(t.g, s.money); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check a snapshot of the bank workload: the recorded balances plus the
+// transfers recorded in transit must add up to the money the system
+// started with
+func verifyMoney[M collect.Message](g collect.Global[M], total int) error {
+	sum, err := g.Cut().Money()
+	if err != nil {
+		return fmt.Errorf("snapshot #%d: %v", g.ID, err)
+	}
+	if sum != total {
+		return fmt.Errorf("snapshot #%d holds %d, the system started with %d", g.ID, sum, total)
+	}
+	return nil
+}
+
+// Detect a stable predicate with snapshots from the first process, see
+// predicate.Detector
+func (s *system[M, P]) detector(pred predicate.Predicate, max int) *predicate.Detector {
+	return &predicate.Detector{
+		Pred:     pred,
+		Initiate: func() int { return s.initiate(s.procs[0]) },
+		Collect: func(id int) (predicate.Cut, bool) {
+			g := s.collector.Collect(id, 0)
+			return g.Cut(), g.Complete()
+		},
+		Max: max,
+		Out: s.out,
+	}
+}
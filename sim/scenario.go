@@ -0,0 +1,189 @@
+// Package sim drives snapshot algorithms through scripted runs: scenarios
+// of sends, snapshot initiations, crashes and recoveries, written in a
+// small text format and played against the processes of a topology,
+// either in real time or under a seeded Scheduler that makes every run
+// reproducible, or under every interleaving the Explorer can reach.
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"snapshot/node"
+)
+
+// ActionKind is what a scenario line makes a process do
+type ActionKind string
+
+const (
+	SendAction     ActionKind = "send"
+	SnapshotAction ActionKind = "snapshot"
+	CrashAction    ActionKind = "crash"
+	RecoverAction  ActionKind = "recover"
+)
+
+// Action is one scenario line
+type Action struct {
+	Step     int
+	Kind     ActionKind
+	From, To int    // From is the acting process, To the receiver of a send
+	Data     string // payload of a send
+	Line     int    // line number in the scenario file
+}
+
+// Scenario is a workload script, one action per line:
+//
+//	# comments and blank lines are ignored
+//	at step 3 P1 sends A to P2
+//	at step 5 P1 initiates snapshot
+//	at step 7 P2 crashes
+//	at step 9 P2 recovers
+//
+// Actions run in step order, actions sharing a step in file order.
+type Scenario struct {
+	Actions []Action
+}
+
+// ParseScenario reads a scenario from r
+func ParseScenario(r io.Reader) (Scenario, error) {
+	var sc Scenario
+	lines := bufio.NewScanner(r)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		a, err := parseAction(strings.Fields(line))
+		if err != nil {
+			return Scenario{}, fmt.Errorf("line %d: %v", n, err)
+		}
+		a.Line = n
+		sc.Actions = append(sc.Actions, a)
+	}
+	if err := lines.Err(); err != nil {
+		return Scenario{}, err
+	}
+	sort.SliceStable(sc.Actions, func(i, j int) bool { return sc.Actions[i].Step < sc.Actions[j].Step })
+	return sc, nil
+}
+
+// LoadScenario reads a scenario file
+func LoadScenario(path string) (Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer f.Close()
+	sc, err := ParseScenario(f)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return sc, nil
+}
+
+// at step <n> P<i> sends <data> to P<j>
+// at step <n> P<i> initiates snapshot
+// at step <n> P<i> crashes
+// at step <n> P<i> recovers
+func parseAction(w []string) (Action, error) {
+	if len(w) < 4 || !strings.EqualFold(w[0], "at") || !strings.EqualFold(w[1], "step") {
+		return Action{}, fmt.Errorf("expected \"at step <n> P<i> ...\"")
+	}
+	step, err := strconv.Atoi(w[2])
+	if err != nil || step < 0 {
+		return Action{}, fmt.Errorf("bad step %q", w[2])
+	}
+	from, err := parseProcess(w[3])
+	if err != nil {
+		return Action{}, err
+	}
+	a := Action{Step: step, From: from}
+	switch {
+	case len(w) == 8 && strings.EqualFold(w[4], "sends") && strings.EqualFold(w[6], "to"):
+		a.Kind, a.Data = SendAction, w[5]
+		if a.To, err = parseProcess(w[7]); err != nil {
+			return Action{}, err
+		}
+	case len(w) == 6 && strings.EqualFold(w[4], "initiates") && strings.EqualFold(w[5], "snapshot"):
+		a.Kind = SnapshotAction
+	case len(w) == 5 && strings.EqualFold(w[4], "crashes"):
+		a.Kind = CrashAction
+	case len(w) == 5 && strings.EqualFold(w[4], "recovers"):
+		a.Kind = RecoverAction
+	default:
+		return Action{}, fmt.Errorf("expected \"sends <data> to P<j>\", \"initiates snapshot\", \"crashes\" or \"recovers\"")
+	}
+	return a, nil
+}
+
+func parseProcess(s string) (int, error) {
+	if len(s) < 2 || (s[0] != 'P' && s[0] != 'p') {
+		return 0, fmt.Errorf("bad process %q, want P<id>", s)
+	}
+	id, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return 0, fmt.Errorf("bad process %q, want P<id>", s)
+	}
+	return id, nil
+}
+
+// Validate checks that every action names a process and channel of top
+func (sc Scenario) Validate(top node.Topology) error {
+	nodes := map[int]bool{}
+	for _, id := range top.Nodes {
+		nodes[id] = true
+	}
+	edges := map[node.Channel]bool{}
+	for _, e := range top.Edges {
+		edges[e] = true
+	}
+	for _, a := range sc.Actions {
+		if !nodes[a.From] {
+			return fmt.Errorf("line %d: no process P%d", a.Line, a.From)
+		}
+		if a.Kind == SendAction && !edges[node.Channel{From: a.From, To: a.To}] {
+			return fmt.Errorf("line %d: no channel P%d->P%d", a.Line, a.From, a.To)
+		}
+	}
+	return nil
+}
+
+// HasCrashes reports whether any process crashes or recovers
+func (sc Scenario) HasCrashes() bool {
+	for _, a := range sc.Actions {
+		if a.Kind == CrashAction || a.Kind == RecoverAction {
+			return true
+		}
+	}
+	return false
+}
+
+// Play runs the actions in real time, one step every tick
+func (sc Scenario) Play(tick time.Duration, do func(Action)) {
+	start := time.Now()
+	for _, a := range sc.Actions {
+		time.Sleep(time.Until(start.Add(time.Duration(a.Step) * tick)))
+		do(a)
+	}
+}
+
+// GenerateScenario builds a workload for any topology: every process messages
+// each neighbour, the first process initiates a snapshot, then another round
+func GenerateScenario(top node.Topology) Scenario {
+	var sc Scenario
+	round := func(step, k int) {
+		for _, e := range top.Edges {
+			sc.Actions = append(sc.Actions, Action{Step: step, Kind: SendAction, From: e.From, To: e.To, Data: fmt.Sprintf("%d.%d", e.From, k)})
+		}
+	}
+	round(1, 1)
+	sc.Actions = append(sc.Actions, Action{Step: 2, Kind: SnapshotAction, From: top.Nodes[0]})
+	round(2, 2)
+	return sc
+}
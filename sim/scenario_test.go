@@ -0,0 +1,82 @@
+package sim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"snapshot/node"
+)
+
+func TestParseScenario(t *testing.T) {
+	sc, err := ParseScenario(strings.NewReader(`
+# P2 crashes with A in transit
+at step 5 P1 initiates snapshot
+at step 3 p1 sends A to P2
+  at step 5 P2 crashes
+
+AT STEP 9 P2 RECOVERS
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Action{
+		{Step: 3, Kind: SendAction, From: 1, To: 2, Data: "A", Line: 4},
+		{Step: 5, Kind: SnapshotAction, From: 1, Line: 3},
+		{Step: 5, Kind: CrashAction, From: 2, Line: 5},
+		{Step: 9, Kind: RecoverAction, From: 2, Line: 7},
+	}
+	if !reflect.DeepEqual(sc.Actions, want) {
+		t.Fatalf("got %+v\nwant %+v", sc.Actions, want)
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	tests := []struct {
+		scenario string
+		want     string
+	}{
+		{"step 3 P1 sends A to P2", `line 1: expected "at step <n> P<i> ..."`},
+		{"at step", `line 1: expected "at step <n> P<i> ..."`},
+		{"at step three P1 initiates snapshot", `line 1: bad step "three"`},
+		{"at step -1 P1 initiates snapshot", `line 1: bad step "-1"`},
+		{"at step 3 Q1 crashes", `line 1: bad process "Q1", want P<id>`},
+		{"at step 3 P crashes", `line 1: bad process "P", want P<id>`},
+		{"at step 3 P1 sends A to Px", `line 1: bad process "Px", want P<id>`},
+		{"at step 3 P1 sends A P2", `line 1: expected "sends <data> to P<j>"`},
+		{"at step 3 P1 initiates", `line 1: expected "sends <data> to P<j>"`},
+		{"# fine\n\nat step 3 P1 explodes", `line 3: expected "sends <data> to P<j>"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseScenario(strings.NewReader(tt.scenario))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %s", tt.scenario, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	line := node.Line(3)
+	tests := []struct {
+		scenario string
+		want     string // "" for a scenario the line can run
+	}{
+		{"at step 1 P1 sends A to P2\nat step 2 P3 initiates snapshot", ""},
+		{"at step 1 P4 initiates snapshot", "line 1: no process P4"},
+		{"at step 1 P1 sends A to P3", "line 1: no channel P1->P3"},
+		{"at step 1 P1 sends A to P4", "line 1: no channel P1->P4"},
+	}
+	for _, tt := range tests {
+		sc, err := ParseScenario(strings.NewReader(tt.scenario))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sc.Validate(line)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q: %v", tt.scenario, err)
+		case tt.want != "" && (err == nil || err.Error() != tt.want):
+			t.Errorf("%q: got error %v, want %s", tt.scenario, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"snapshot/node"
+)
+
+// Scheduler is a deterministic in-memory transport. Sends are queued per
+// channel and nothing is delivered until the scheduler picks a channel, so
+// a run depends only on the scenario and the seed.
+type Scheduler struct {
+	queues   map[node.Channel][]interface{}
+	channels []node.Channel // every channel, in a fixed order
+	handlers map[int]func(from int, v interface{})
+	rng      *rand.Rand
+
+	// Reorder is the chance that a delivery takes a later message off the
+	// channel instead of the oldest one, picked among the oldest Window
+	// (0 for all of them). The default of 0 keeps every channel FIFO.
+	Reorder float64
+	Window  int
+
+	// Choose picks which of the non-empty channels delivers next, seeded
+	// random by default
+	Choose func(enabled []node.Channel) int
+	// Schedule is every channel that delivered, in order
+	Schedule []node.Channel
+	// OnStep, if set, is called at every step of Run once the step's
+	// actions are done, before its delivery
+	OnStep func(step int)
+	// Busy, if set, keeps Run stepping while it reports work left outside
+	// the channels
+	Busy func() bool
+
+	stopped bool
+}
+
+// NewScheduler creates queues for every edge of the topology
+func NewScheduler(top node.Topology, seed int64) *Scheduler {
+	rng := rand.New(rand.NewSource(seed))
+	s := &Scheduler{
+		queues:   map[node.Channel][]interface{}{},
+		handlers: map[int]func(int, interface{}){},
+		rng:      rng,
+		Choose:   func(enabled []node.Channel) int { return rng.Intn(len(enabled)) },
+	}
+	for _, e := range top.Edges {
+		s.queues[e] = nil
+		s.channels = append(s.channels, e)
+	}
+	sort.Slice(s.channels, func(i, j int) bool {
+		a, b := s.channels[i], s.channels[j]
+		return a.From < b.From || a.From == b.From && a.To < b.To
+	})
+	return s
+}
+
+// Attach registers the handler that receives everything delivered to process id
+func (s *Scheduler) Attach(id int, handle func(from int, v interface{})) {
+	s.handlers[id] = handle
+}
+
+func (s *Scheduler) Send(from, to int, v interface{}) error {
+	c := node.Channel{From: from, To: to}
+	if _, ok := s.queues[c]; !ok {
+		return fmt.Errorf("no channel P%d->P%d", from, to)
+	}
+	s.queues[c] = append(s.queues[c], v)
+	return nil
+}
+
+// Inbox is never ready: the scheduler calls the attached handlers itself,
+// so processes on it are not Run
+func (s *Scheduler) Inbox(id int) <-chan node.Delivery {
+	return nil
+}
+
+// Reset discards everything in flight
+func (s *Scheduler) Reset() {
+	for c := range s.queues {
+		s.queues[c] = nil
+	}
+}
+
+// Enabled lists the channels with something to deliver, in a fixed order
+func (s *Scheduler) Enabled() []node.Channel {
+	var enabled []node.Channel
+	for _, c := range s.channels {
+		if len(s.queues[c]) > 0 {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// Step delivers the head of one chosen channel, or with reordering maybe
+// a later message, false if all are empty
+func (s *Scheduler) Step() bool {
+	enabled := s.Enabled()
+	if len(enabled) == 0 {
+		return false
+	}
+	c := enabled[s.Choose(enabled)]
+	q := s.queues[c]
+	i := 0
+	if n := len(q); n > 1 && s.Reorder > 0 && s.rng.Float64() < s.Reorder {
+		if s.Window > 0 && s.Window < n {
+			n = s.Window
+		}
+		if n > 1 {
+			i = 1 + s.rng.Intn(n-1)
+		}
+	}
+	v := q[i]
+	s.queues[c] = append(q[:i:i], q[i+1:]...)
+	s.Schedule = append(s.Schedule, c)
+	if h := s.handlers[c.To]; h != nil {
+		h(c.From, v)
+	}
+	return true
+}
+
+// Stop makes Run return after the current step, the rest of the run being
+// of no interest
+func (s *Scheduler) Stop() {
+	s.stopped = true
+}
+
+// Run plays the scenario in logical time: each step first performs that
+// step's actions, then delivers one message. After the last action it
+// delivers until every channel is empty and nothing is Busy, unless
+// stopped first.
+func (s *Scheduler) Run(sc Scenario, do func(Action)) {
+	i := 0
+	for step := 0; !s.stopped && (i < len(sc.Actions) || len(s.Enabled()) > 0 || s.Busy != nil && s.Busy()); step++ {
+		for ; i < len(sc.Actions) && sc.Actions[i].Step <= step; i++ {
+			do(sc.Actions[i])
+		}
+		if s.OnStep != nil {
+			s.OnStep(step)
+		}
+		s.Step()
+	}
+}
@@ -0,0 +1,335 @@
+// Package spacetime draws space-time diagrams of snapshot runs, as SVG or
+// as text: a line per process, an arrow per message, and the cut a
+// snapshot made through the points where the processes recorded.
+package spacetime
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"snapshot/trace"
+)
+
+// Diagram is a space-time diagram of a run and the cut one snapshot made of
+// it: a line per process with time flowing along it, an arrow per message,
+// and the cut through the points where the processes recorded their state
+type Diagram struct {
+	Title     string
+	Processes []int
+	Points    []Point
+	Arrows    []Arrow
+	Cut       map[int]int // process -> time it recorded its state
+	Coloured  bool        // paint lines and messages white before the cut, red after
+}
+
+// Point is an event on the line of a process
+type Point struct {
+	Process, Time int
+	Label         string
+	Note          string // longer description, such as the state recorded
+}
+
+// Fate is where a message ended up relative to the cut
+type Fate int
+
+const (
+	Inside   Fate = iota // sent and received on the same side of the cut
+	Recorded             // crossed the cut and is in the channel state
+	Missing              // crossed the cut but is missing from the channel state
+	Backward             // received before the cut but sent after it
+	InFlight             // never received
+)
+
+// Arrow is a message from one process line to another
+type Arrow struct {
+	From, To       int
+	Seq            int // sender's sequence number, 0 for markers and control messages
+	Sent, Received int // times, Received 0 if never
+	Label          string
+	Control        bool // marker or control message rather than application data
+	Fate           Fate
+}
+
+// before reports whether process p was still before the cut at time t, as
+// it always is without a recorded state
+func (d *Diagram) before(p, t int) bool {
+	cut, ok := d.Cut[p]
+	return !ok || t < cut
+}
+
+// Settle works out the fate of every message from the cut and the
+// messages recorded in channel states
+func (d *Diagram) Settle(recorded map[trace.MessageID]bool) {
+	for i := range d.Arrows {
+		a := &d.Arrows[i]
+		_, cut := d.Cut[a.To]
+		switch {
+		case a.Control:
+			a.Fate = Inside
+		case recorded[trace.MessageID{From: a.From, Seq: a.Seq}]:
+			a.Fate = Recorded
+		case a.Received == 0:
+			a.Fate = InFlight
+		case d.before(a.From, a.Sent) && !d.before(a.To, a.Received) && cut:
+			a.Fate = Missing
+		case !d.before(a.From, a.Sent) && d.before(a.To, a.Received):
+			a.Fate = Backward
+		default:
+			a.Fate = Inside
+		}
+	}
+}
+
+// note describes a message whose fate is worth pointing out, "" otherwise
+func (a Arrow) note() string {
+	var fate string
+	switch a.Fate {
+	case Recorded:
+		fate = "recorded in the channel state"
+	case Missing:
+		fate = "crossed the cut but is missing from the channel state!"
+	case Backward:
+		fate = "sent after the cut but received before it!"
+	case InFlight:
+		fate = "never received"
+	default:
+		return ""
+	}
+	return fmt.Sprintf("%q P%d->P%d %s", a.Label, a.From, a.To, fate)
+}
+
+// slots numbers the distinct times in the diagram from 1, so the drawing
+// has no gaps where other snapshots' events were left out
+func (d *Diagram) slots() (map[int]int, int) {
+	seen := map[int]bool{}
+	for _, pt := range d.Points {
+		seen[pt.Time] = true
+	}
+	for _, a := range d.Arrows {
+		seen[a.Sent] = true
+		if a.Received != 0 {
+			seen[a.Received] = true
+		}
+	}
+	var times []int
+	for t := range seen {
+		times = append(times, t)
+	}
+	sort.Ints(times)
+	slot := map[int]int{}
+	for i, t := range times {
+		slot[t] = i + 1
+	}
+	return slot, len(times)
+}
+
+const (
+	svgLeft = 60 // room for the process names
+	svgTop  = 50 // room for the title
+	svgRow  = 70 // between process lines
+	svgStep = 40 // between consecutive events
+)
+
+// SVG draws the diagram with time running left to right
+func (d Diagram) SVG(w io.Writer) error {
+	slot, n := d.slots()
+	row := map[int]int{}
+	for i, p := range d.Processes {
+		row[p] = i
+	}
+	x := func(t int) int { return svgLeft + svgStep*slot[t] }
+	y := func(p int) int { return svgTop + svgRow*row[p] }
+	end := svgLeft + svgStep*(n+1)
+	width, height := end+svgLeft, svgTop+svgRow*len(d.Processes)+60
+	if width < 960 {
+		width = 960 // the legend
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="11">`+"\n", width, height)
+	b.WriteString("<defs>\n")
+	for _, c := range []string{"black", "red", "blue", "darkorange", "magenta", "gray"} {
+		fmt.Fprintf(&b, `<marker id="head-%s" markerWidth="8" markerHeight="6" refX="8" refY="3" orient="auto"><path d="M0,0 L8,3 L0,6 z" fill="%s"/></marker>`+"\n", c, c)
+	}
+	b.WriteString("</defs>\n")
+	fmt.Fprintf(&b, `<text x="%d" y="20" font-size="14">%s</text>`+"\n", svgLeft, html.EscapeString(d.Title))
+
+	// process lines, split at the cut when coloured
+	for _, p := range d.Processes {
+		fmt.Fprintf(&b, `<text x="10" y="%d">P%d</text>`+"\n", y(p)+4, p)
+		cut, ok := d.Cut[p]
+		if !d.Coloured || !ok {
+			fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", svgLeft, y(p), end, y(p))
+			continue
+		}
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="6" fill="white" stroke="black"/>`+"\n", svgLeft, y(p)-3, x(cut)-svgLeft)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="6" fill="red" stroke="black"/>`+"\n", x(cut), y(p)-3, end-x(cut))
+	}
+
+	// messages, coloured by fate
+	for _, a := range d.Arrows {
+		colour, dash, stroke := "black", "", 1
+		switch {
+		case a.Control:
+			colour, dash = "blue", ` stroke-dasharray="4,3"`
+		case a.Fate == Recorded:
+			colour, stroke = "darkorange", 2
+		case a.Fate == Missing || a.Fate == Backward:
+			colour, stroke = "magenta", 3
+		case a.Fate == InFlight:
+			colour, dash = "gray", ` stroke-dasharray="2,2"`
+		case d.Coloured && !d.before(a.From, a.Sent):
+			colour = "red"
+		}
+		x2 := end
+		if a.Received != 0 {
+			x2 = x(a.Received)
+		}
+		x1, y1, y2 := x(a.Sent), y(a.From), y(a.To)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%d"%s marker-end="url(#head-%s)"/>`+"\n",
+			x1, y1, x2, y2, colour, stroke, dash, colour)
+		if a.Label != "" {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s">%s</text>`+"\n", (x1+x2)/2+3, (y1+y2)/2-3, colour, html.EscapeString(a.Label))
+		}
+	}
+
+	// events, the recording ones as squares
+	for _, pt := range d.Points {
+		if pt.Note != "" {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" fill="purple">%s</text>`+"\n", x(pt.Time)+6, y(pt.Process)+16, html.EscapeString(pt.Note))
+		}
+		if cut, ok := d.Cut[pt.Process]; ok && cut == pt.Time {
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="8" height="8" fill="purple"><title>%s</title></rect>`+"\n",
+				x(pt.Time)-4, y(pt.Process)-4, html.EscapeString(pt.Label))
+			continue
+		}
+		fmt.Fprintf(&b, `<circle cx="%d" cy="%d" r="3"><title>%s</title></circle>`+"\n", x(pt.Time), y(pt.Process), html.EscapeString(pt.Label))
+	}
+
+	// the cut, zigzagging through the recording points
+	var cut []string
+	for _, p := range d.Processes {
+		if t, ok := d.Cut[p]; ok {
+			cut = append(cut, fmt.Sprintf("%d,%d %d,%d", x(t), y(p)-svgRow/2, x(t), y(p)+svgRow/2))
+		}
+	}
+	if len(cut) > 0 {
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="purple" stroke-width="2" stroke-dasharray="6,4"/>`+"\n", strings.Join(cut, " "))
+	}
+
+	legend := "orange: recorded in a channel state, magenta: inconsistent, gray: never received, purple: the cut"
+	if d.Coloured {
+		legend = "white/red: before/after the cut, blue: control messages, " + legend
+	} else {
+		legend = "blue: markers, " + legend
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`+"\n", svgLeft, height-20, legend)
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// asciiWidth is the width of a process column in ASCII diagrams
+const asciiWidth = 16
+
+// ASCII draws the diagram as text with time running down the page, a row
+// per event. A process line is drawn | before the cut and : after it, and
+// messages worth pointing out are noted beside the row that settled them.
+func (d Diagram) ASCII(w io.Writer) error {
+	slot, _ := d.slots()
+	at := map[int]map[int]string{} // time -> process -> label
+	var times []int
+	for _, pt := range d.Points {
+		if at[pt.Time] == nil {
+			at[pt.Time] = map[int]string{}
+			times = append(times, pt.Time)
+		}
+		at[pt.Time][pt.Process] = pt.Label
+	}
+	sort.Ints(times)
+	notes := map[int][]string{}
+	for _, pt := range d.Points {
+		if pt.Note != "" {
+			notes[pt.Time] = append(notes[pt.Time], pt.Note)
+		}
+	}
+	for _, a := range d.Arrows {
+		if s := a.note(); s != "" {
+			t := a.Received
+			if t == 0 {
+				t = a.Sent
+			}
+			notes[t] = append(notes[t], s)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(d.Title + "\n")
+	if d.Coloured {
+		b.WriteString("| white, before the cut   : red, after it   # the cut\n\n")
+	} else {
+		b.WriteString("| before the cut   : after it   # the cut\n\n")
+	}
+	header := "time  "
+	for _, p := range d.Processes {
+		header += pad(fmt.Sprintf("P%d", p))
+	}
+	b.WriteString(strings.TrimRight(header, " ") + "\n")
+	for _, t := range times {
+		line := fmt.Sprintf("%4d  ", slot[t])
+		for _, p := range d.Processes {
+			label, ok := at[t][p]
+			switch {
+			case ok && d.Cut[p] == t:
+				line += pad("# " + label)
+			case ok:
+				line += pad(label)
+			case d.before(p, t):
+				line += pad("|")
+			default:
+				line += pad(":")
+			}
+		}
+		line += strings.Join(notes[t], "; ")
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// pad fits s into an ASCII column
+func pad(s string) string {
+	if len(s) >= asciiWidth {
+		s = s[:asciiWidth-2] + "~"
+	}
+	return s + strings.Repeat(" ", asciiWidth-len(s))
+}
+
+// WriteDiagram writes the diagram of snapshot id to path with the ID added
+// to the name, as SVG for a .svg path and as text otherwise. A path of "-"
+// writes text to standard output.
+func WriteDiagram(path string, id int, d Diagram) error {
+	if path == "-" {
+		fmt.Println()
+		return d.ASCII(os.Stdout)
+	}
+	ext := filepath.Ext(path)
+	f, err := os.Create(fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), id, ext))
+	if err != nil {
+		return err
+	}
+	if ext == ".svg" {
+		err = d.SVG(f)
+	} else {
+		err = d.ASCII(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package spacetime
+
+import (
+	"fmt"
+	"sort"
+
+	"snapshot/node"
+	"snapshot/trace"
+)
+
+// Style is how the snapshots of an algorithm look in a diagram: the control
+// messages they send, and what marks the points where processes record
+type Style struct {
+	ControlSend, ControlReceive trace.EventKind // events of the control messages, such as markers
+	Control                     string          // control messages on the process lines, as in "mark >P2"
+	Arrow                       func(send trace.Event) string
+	Record                      string // label of the points where processes record
+	Coloured                    bool   // see Diagram
+}
+
+// FromTrace draws the run described by events with the cut snapshot id made
+// of it: the application messages, the control messages of the snapshot,
+// and where each process recorded its state. recorded are the messages the
+// snapshot recorded in transit.
+func FromTrace(events []trace.Event, id int, recorded []trace.MessageID, s Style) Diagram {
+	d := Diagram{Cut: map[int]int{}, Coloured: s.Coloured}
+	procs := map[int]bool{}
+	sent := map[trace.MessageID]int{} // message -> its arrow
+	control := map[node.Channel]int{} // channel -> arrow of the control message on it
+	for _, e := range events {
+		procs[e.Process] = true
+		switch e.Kind {
+		case trace.SendEvent:
+			sent[trace.MessageID{From: e.From, Seq: e.Seq}] = len(d.Arrows)
+			d.Arrows = append(d.Arrows, Arrow{From: e.From, To: e.To, Seq: e.Seq, Sent: e.Time, Label: e.Data})
+			d.Points = append(d.Points, Point{Process: e.Process, Time: e.Time, Label: fmt.Sprintf("%s >P%d", e.Data, e.To)})
+		case trace.ReceiveEvent:
+			if i, ok := sent[trace.MessageID{From: e.From, Seq: e.Seq}]; ok {
+				d.Arrows[i].Received = e.Time
+			}
+			d.Points = append(d.Points, Point{Process: e.Process, Time: e.Time, Label: fmt.Sprintf("%s <P%d", e.Data, e.From)})
+		case s.ControlSend:
+			if e.Snapshot != id {
+				continue
+			}
+			control[node.Channel{From: e.From, To: e.To}] = len(d.Arrows)
+			d.Arrows = append(d.Arrows, Arrow{From: e.From, To: e.To, Sent: e.Time, Label: s.Arrow(e), Control: true})
+			d.Points = append(d.Points, Point{Process: e.Process, Time: e.Time, Label: fmt.Sprintf("%s >P%d", s.Control, e.To)})
+		case s.ControlReceive:
+			if e.Snapshot != id {
+				continue
+			}
+			if i, ok := control[node.Channel{From: e.From, To: e.To}]; ok {
+				d.Arrows[i].Received = e.Time
+			}
+			d.Points = append(d.Points, Point{Process: e.Process, Time: e.Time, Label: fmt.Sprintf("%s <P%d", s.Control, e.From)})
+		case trace.RecordEvent:
+			if e.Snapshot != id {
+				continue
+			}
+			d.Cut[e.Process] = e.Time
+			d.Points = append(d.Points, Point{Process: e.Process, Time: e.Time, Label: s.Record, Note: fmt.Sprintf("P%d recorded '%s' at clock %d", e.Process, e.State, e.Clock)})
+		}
+	}
+	for pid := range procs {
+		d.Processes = append(d.Processes, pid)
+	}
+	sort.Ints(d.Processes)
+
+	in := map[trace.MessageID]bool{}
+	for _, m := range recorded {
+		in[m] = true
+	}
+	d.Settle(in)
+	return d
+}
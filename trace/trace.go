@@ -0,0 +1,166 @@
+// Package trace records what the processes of a run do, whichever snapshot
+// algorithm they run: the events of every process in order, sent to a
+// Sink as they happen, and the checks that a snapshot is a consistent cut
+// of the run they describe.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"snapshot/vclock"
+)
+
+// EventKind is the type of a traced event. Algorithms add kinds of their
+// own for their control messages.
+type EventKind string
+
+const (
+	SendEvent    EventKind = "send"
+	ReceiveEvent EventKind = "receive"
+	RecordEvent  EventKind = "record" // process recorded its local state
+)
+
+// Event is one step in the local history of a process
+type Event struct {
+	Time     int                `json:"time"` // position in the trace, filled in when traced
+	Process  int                `json:"process"`
+	Kind     EventKind          `json:"kind"`
+	From     int                `json:"from,omitempty"` // channel of a send or receive
+	To       int                `json:"to,omitempty"`
+	Seq      int                `json:"seq,omitempty"`      // sender's sequence number of the message
+	Data     string             `json:"data,omitempty"`     // payload of a message
+	Snapshot int                `json:"snapshot,omitempty"` // snapshot of a record or control event, or the one a message was sent in
+	State    string             `json:"state,omitempty"`    // recorded state of a record event
+	Clock    int                `json:"clock,omitempty"`    // Lamport clock of the process at the event
+	Vector   vclock.VectorClock `json:"vector,omitempty"`   // vector clock of the process at the event
+}
+
+// MessageID identifies an application message by sender and the sender's
+// sequence number
+type MessageID struct {
+	From, Seq int
+}
+
+// Trace is the full history of a run. Events of one process appear in the
+// order that process executed them.
+type Trace struct {
+	mu     sync.Mutex
+	events []Event
+	time   int
+
+	Sink Sink // optional, also gets every event as it is traced
+}
+
+// Sink is where traced events go for observers outside the run
+type Sink interface {
+	Emit(e Event)
+}
+
+// Add is a no-op on a nil trace so processes can run untraced
+func (t *Trace) Add(e Event) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.time++
+	e.Time = t.time
+	t.events = append(t.events, e)
+	if t.Sink != nil {
+		t.Sink.Emit(e)
+	}
+}
+
+// Clear drops the events traced so far, later ones carrying on from the
+// time of the last, as a run starts over after a rollback
+func (t *Trace) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = nil
+}
+
+// Events returns a copy of everything traced so far
+func (t *Trace) Events() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Event{}, t.events...)
+}
+
+// Merge interleaves the traces processes running apart wrote into one run,
+// ordered by Lamport clock, which keeps the history of every process in
+// order and puts every send before its receive. Times are numbered afresh.
+func Merge(traces ...[]Event) []Event {
+	var events []Event
+	for _, es := range traces {
+		events = append(events, es...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Clock != events[j].Clock {
+			return events[i].Clock < events[j].Clock
+		}
+		return events[i].Process < events[j].Process
+	})
+	for i := range events {
+		events[i].Time = i + 1
+	}
+	return events
+}
+
+// JSONLWriter is a Sink writing one JSON object per event and line
+type JSONLWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	b := bufio.NewWriter(w)
+	return &JSONLWriter{w: b, enc: json.NewEncoder(b)}
+}
+
+// Emit keeps the first write error for Flush to report
+func (j *JSONLWriter) Emit(e Event) {
+	if j.err == nil {
+		j.err = j.enc.Encode(e)
+	}
+}
+
+// Flush writes out buffered events and reports any error so far
+func (j *JSONLWriter) Flush() error {
+	if j.err != nil {
+		return j.err
+	}
+	return j.w.Flush()
+}
+
+// ReadEvents reads back the events a JSONLWriter wrote
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(r)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
+
+// Describe an event for causality queries
+func Describe(e Event) string {
+	s := fmt.Sprintf("event %d, P%d %s", e.Time, e.Process, e.Kind)
+	switch e.Kind {
+	case SendEvent:
+		s += fmt.Sprintf(" %q to P%d", e.Data, e.To)
+	case ReceiveEvent:
+		s += fmt.Sprintf(" %q from P%d", e.Data, e.From)
+	}
+	return fmt.Sprintf("%s at %v", s, e.Vector)
+}
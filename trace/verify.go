@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+
+	"snapshot/vclock"
+)
+
+// message send/receive relative to the cut
+type msgFate struct {
+	to                     int
+	sent, received         bool
+	sentBefore, recvBefore bool
+}
+
+// Verify checks that a snapshot is a consistent cut of the run described by
+// events. The cut goes through the record event of the snapshot on every
+// process in recorded, which holds the messages each one recorded in
+// transit per incoming channel. Every message received before the cut must
+// have been sent before it, and the messages recorded must be exactly
+// those sent before the sender's cut and received after the receiver's.
+// It returns nil if the cut is consistent.
+func Verify(events []Event, snapshot int, recorded map[int]map[int][]MessageID) error {
+	var errs []error
+
+	// position of the cut in each process's local history
+	cut := map[int]int{}
+	pos := map[int]int{}
+	for _, e := range events {
+		if e.Kind == RecordEvent && e.Snapshot == snapshot {
+			if _, dup := cut[e.Process]; dup {
+				errs = append(errs, fmt.Errorf("P%d recorded snapshot #%d twice", e.Process, snapshot))
+			} else {
+				cut[e.Process] = pos[e.Process]
+			}
+		}
+		pos[e.Process]++
+	}
+	for pid := range recorded {
+		if _, ok := cut[pid]; !ok {
+			errs = append(errs, fmt.Errorf("P%d has no record event for snapshot #%d", pid, snapshot))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// where every message was sent and received relative to the cut
+	fates := map[MessageID]*msgFate{}
+	fate := func(id MessageID) *msgFate {
+		if fates[id] == nil {
+			fates[id] = &msgFate{}
+		}
+		return fates[id]
+	}
+	pos = map[int]int{}
+	for _, e := range events {
+		before := pos[e.Process] < cut[e.Process]
+		pos[e.Process]++
+		switch e.Kind {
+		case SendEvent:
+			f := fate(MessageID{e.From, e.Seq})
+			f.to, f.sent, f.sentBefore = e.To, true, before
+		case ReceiveEvent:
+			f := fate(MessageID{e.From, e.Seq})
+			if f.received {
+				errs = append(errs, fmt.Errorf("message %d#%d received twice", e.From, e.Seq))
+			}
+			f.received, f.recvBefore = true, before
+		}
+	}
+
+	// no message may cross the cut backwards
+	for id, f := range fates {
+		if f.received && !f.sent {
+			errs = append(errs, fmt.Errorf("message %d#%d received but never sent", id.From, id.Seq))
+		} else if f.recvBefore && !f.sentBefore {
+			errs = append(errs, fmt.Errorf("message %d#%d received before the cut of P%d but sent after the cut of P%d",
+				id.From, id.Seq, f.to, id.From))
+		}
+	}
+
+	// channel states must hold exactly the messages crossing the cut forwards
+	seen := map[MessageID]bool{}
+	for to, channels := range recorded {
+		for from, ids := range channels {
+			for _, id := range ids {
+				f := fates[id]
+				switch {
+				case seen[id]:
+					errs = append(errs, fmt.Errorf("message %d#%d recorded twice in channel states", id.From, id.Seq))
+				case f == nil || !f.sent:
+					errs = append(errs, fmt.Errorf("message %d#%d in channel P%d->P%d was never sent", id.From, id.Seq, from, to))
+				case !f.sentBefore:
+					errs = append(errs, fmt.Errorf("message %d#%d in channel P%d->P%d was sent after the cut", id.From, id.Seq, from, to))
+				case f.received && f.recvBefore:
+					errs = append(errs, fmt.Errorf("message %d#%d in channel P%d->P%d was received before the cut", id.From, id.Seq, from, to))
+				}
+				seen[id] = true
+			}
+		}
+	}
+	for id, f := range fates {
+		if f.sentBefore && !f.recvBefore && !seen[id] {
+			if _, ok := recorded[f.to]; ok {
+				errs = append(errs, fmt.Errorf("message %d#%d in transit P%d->P%d is missing from the channel state",
+					id.From, id.Seq, id.From, f.to))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyClocks checks the Lamport and vector clocks in a run: they advance
+// along the history of every process and from every send to its receive
+func VerifyClocks(events []Event) error {
+	var errs []error
+	last := map[int]Event{}
+	sent := map[MessageID]Event{}
+	for _, e := range events {
+		prev, ok := last[e.Process]
+		if e.Clock <= prev.Clock {
+			errs = append(errs, fmt.Errorf("P%d clock goes from %d to %d at its %s event", e.Process, prev.Clock, e.Clock, e.Kind))
+		}
+		if ok && !vclock.HappenedBefore(prev.Vector, e.Vector) {
+			errs = append(errs, fmt.Errorf("P%d vector clock goes from %v to %v at its %s event", e.Process, prev.Vector, e.Vector, e.Kind))
+		}
+		last[e.Process] = e
+		switch e.Kind {
+		case SendEvent:
+			sent[MessageID{e.From, e.Seq}] = e
+		case ReceiveEvent:
+			s, ok := sent[MessageID{e.From, e.Seq}]
+			if ok && e.Clock <= s.Clock {
+				errs = append(errs, fmt.Errorf("message %d#%d sent at clock %d but received at %d", e.From, e.Seq, s.Clock, e.Clock))
+			}
+			if ok && !vclock.HappenedBefore(s.Vector, e.Vector) {
+				errs = append(errs, fmt.Errorf("message %d#%d sent at %v but received at %v", e.From, e.Seq, s.Vector, e.Vector))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
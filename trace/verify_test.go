@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+)
+
+// Sending and receiving message from#seq on the channel from P<from> to P<to>
+func send(from, to, seq int) Event {
+	return Event{Process: from, Kind: SendEvent, From: from, To: to, Seq: seq}
+}
+
+func receive(from, to, seq int) Event {
+	return Event{Process: to, Kind: ReceiveEvent, From: from, To: to, Seq: seq}
+}
+
+// Recording the state of P<pid> for snapshot #1
+func record(pid int) Event {
+	return Event{Process: pid, Kind: RecordEvent, Snapshot: 1}
+}
+
+// Hand-built cuts of two processes, P1 sending to P2
+func TestVerify(t *testing.T) {
+	none := map[int]map[int][]MessageID{1: {}, 2: {}}
+	inTransit := map[int]map[int][]MessageID{1: {}, 2: {1: {{1, 1}}}}
+	tests := []struct {
+		name     string
+		events   []Event
+		recorded map[int]map[int][]MessageID
+		want     string // in the error, "" for a consistent cut
+	}{
+		{"delivered before the cut",
+			[]Event{send(1, 2, 1), receive(1, 2, 1), record(1), record(2)}, none, ""},
+		{"in transit",
+			[]Event{send(1, 2, 1), record(1), record(2), receive(1, 2, 1)}, inTransit, ""},
+		{"received never sent",
+			[]Event{receive(1, 2, 5), record(1), record(2)}, none, "received but never sent"},
+		{"crossing backwards",
+			[]Event{record(1), send(1, 2, 1), receive(1, 2, 1), record(2)}, none, "received before the cut of P2 but sent after"},
+		{"missing in transit",
+			[]Event{send(1, 2, 1), record(1), record(2), receive(1, 2, 1)}, none, "missing from the channel state"},
+		{"recorded but received before the cut",
+			[]Event{send(1, 2, 1), receive(1, 2, 1), record(1), record(2)}, inTransit, "received before the cut"},
+		{"recorded but sent after the cut",
+			[]Event{record(1), record(2), send(1, 2, 1), receive(1, 2, 1)}, inTransit, "sent after the cut"},
+		{"recorded never sent",
+			[]Event{record(1), record(2)}, inTransit, "was never sent"},
+		{"received twice",
+			[]Event{send(1, 2, 1), receive(1, 2, 1), receive(1, 2, 1), record(1), record(2)}, none, "received twice"},
+		{"no record event",
+			[]Event{send(1, 2, 1), receive(1, 2, 1), record(1)}, none, "P2 has no record event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.events, 1, tt.recorded)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("consistent cut rejected: %v", err)
+			case tt.want != "" && err == nil:
+				t.Fatalf("inconsistent cut accepted, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
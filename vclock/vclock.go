@@ -0,0 +1,125 @@
+// Package vclock is vector clocks: stamping events so that comparing two
+// stamps tells whether one event happened before the other, and checking
+// that points chosen on every process form a consistent cut.
+package vclock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VectorClock counts, per process ID, the events of that process that
+// happened before the one it stamps, that one included
+type VectorClock map[int]int
+
+// Copy is a snapshot of v that later ticks leave alone
+func (v VectorClock) Copy() VectorClock {
+	c := VectorClock{}
+	for id, n := range v {
+		c[id] = n
+	}
+	return c
+}
+
+// Tick counts one more event of process id
+func (v VectorClock) Tick(id int) {
+	v[id]++
+}
+
+// Merge takes in every event w has seen
+func (v VectorClock) Merge(w VectorClock) {
+	for id, n := range w {
+		if n > v[id] {
+			v[id] = n
+		}
+	}
+}
+
+// Order is how two events are related by causality
+type Order int
+
+const (
+	Same       Order = iota // identical clocks, so the same event
+	Before                  // the first happened before the second
+	After                   // the second happened before the first
+	Concurrent              // neither happened before the other
+)
+
+func (o Order) String() string {
+	switch o {
+	case Same:
+		return "is the same event as"
+	case Before:
+		return "happened before"
+	case After:
+		return "happened after"
+	default:
+		return "is concurrent with"
+	}
+}
+
+// Compare orders the events stamped v and w
+func (v VectorClock) Compare(w VectorClock) Order {
+	less, more := false, false
+	for id, n := range v {
+		if n > w[id] {
+			more = true
+		}
+	}
+	for id, n := range w {
+		if n > v[id] {
+			less = true
+		}
+	}
+	switch {
+	case less && more:
+		return Concurrent
+	case less:
+		return Before
+	case more:
+		return After
+	}
+	return Same
+}
+
+func (v VectorClock) String() string {
+	var ids []int
+	for id := range v {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var parts []string
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%d:%d", id, v[id]))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// HappenedBefore reports whether the event stamped a could have
+// influenced the one stamped b
+func HappenedBefore(a, b VectorClock) bool {
+	return a.Compare(b) == Before
+}
+
+// ConsistentCut checks by their vector clocks that points, one per process,
+// form a consistent cut: no point has seen more events of another process
+// than that process had at its own point
+func ConsistentCut(points map[int]VectorClock) error {
+	var ids []int
+	for id := range points {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var errs []error
+	for _, i := range ids {
+		for _, j := range ids {
+			if j != i && points[j][i] > points[i][i] {
+				errs = append(errs, fmt.Errorf("the point of P%d has seen %d events of P%d, which had %d at its own point",
+					j, points[j][i], i, points[i][i]))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
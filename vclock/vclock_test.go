@@ -0,0 +1,54 @@
+package vclock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		v, w VectorClock
+		want Order
+	}{
+		{VectorClock{1: 1, 2: 2}, VectorClock{1: 1, 2: 2}, Same},
+		{VectorClock{}, VectorClock{}, Same},
+		{VectorClock{1: 1}, VectorClock{1: 1, 2: 1}, Before},
+		{VectorClock{1: 1, 2: 0}, VectorClock{1: 2}, Before},
+		{VectorClock{1: 2, 2: 1}, VectorClock{1: 1}, After},
+		{VectorClock{1: 2, 2: 0}, VectorClock{1: 1, 2: 1}, Concurrent},
+		{VectorClock{1: 1}, VectorClock{2: 1}, Concurrent},
+	}
+	for _, tt := range tests {
+		if got := tt.v.Compare(tt.w); got != tt.want {
+			t.Errorf("%v %v %v, want it %v", tt.v, got, tt.w, tt.want)
+		}
+	}
+}
+
+// P1 sends to P2 after its first event, which P2 receives as its second
+func TestConsistentCut(t *testing.T) {
+	tests := []struct {
+		name   string
+		points map[int]VectorClock
+		want   string // in the error, "" for a consistent cut
+	}{
+		{"before the send", map[int]VectorClock{1: {1: 1}, 2: {2: 1}}, ""},
+		{"in transit", map[int]VectorClock{1: {1: 2}, 2: {2: 1}}, ""},
+		{"after the receive", map[int]VectorClock{1: {1: 2}, 2: {1: 2, 2: 2}}, ""},
+		{"received before sent", map[int]VectorClock{1: {1: 1}, 2: {1: 2, 2: 2}},
+			"the point of P2 has seen 2 events of P1, which had 1 at its own point"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ConsistentCut(tt.points)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("consistent cut rejected: %v", err)
+			case tt.want != "" && err == nil:
+				t.Fatalf("inconsistent cut accepted, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}